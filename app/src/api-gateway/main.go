@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"main/middleware"
@@ -8,7 +10,12 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"runtime/debug"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-redis/redis/v8"
@@ -20,9 +27,11 @@ type ServiceConfig struct {
 }
 
 var services = map[string]ServiceConfig{
-	"auth":   {URL: "http://auth-service:8080"},
-	"order":  {URL: "http://order-service:8081"},
-	"wallet": {URL: "http://wallet-service:8083"},
+	"auth":     {URL: "http://auth-service:8080"},
+	"order":    {URL: "http://order-service:8081"},
+	"history":  {URL: "http://order-history-service:8082"},
+	"wallet":   {URL: "http://wallet-service:8083"},
+	"matching": {URL: "http://matching-service:8084"},
 }
 
 func newReverseProxy(targetBase, stripPrefix string) gin.HandlerFunc {
@@ -44,32 +53,96 @@ func newReverseProxy(targetBase, stripPrefix string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Forward user_id/user_type
 		if userID, ok := c.Get("user_id"); ok {
-			c.Request.Header.Set("X-User-ID", toString(userID))
+			c.Request.Header.Set("X-User-ID", middleware.ClaimString(userID))
 		}
 		if userType, ok := c.Get("user_type"); ok {
-			c.Request.Header.Set("X-User-Type", toString(userType))
+			c.Request.Header.Set("X-User-Type", middleware.ClaimString(userType))
 		}
 		proxy.ServeHTTP(c.Writer, c.Request)
 	}
 }
 
-// Helper
-func toString(val interface{}) string {
-	if s, ok := val.(string); ok {
-		return s
+// corsHandler answers browser preflight requests. Registered as a wildcard
+// OPTIONS route so it fires before any group's AuthMiddleware - browsers send
+// preflights without credentials.
+func corsHandler(c *gin.Context) {
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		origin = "*"
+	}
+	c.Header("Access-Control-Allow-Origin", origin)
+	c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, token, X-Async")
+	c.Header("Access-Control-Max-Age", "86400")
+	c.Status(http.StatusNoContent)
+}
+
+// Graceful shutdown state. Once draining is set, new requests are rejected
+// with 503 while in-flight proxy requests are allowed to finish.
+var (
+	inFlightRequests int64
+	draining         int32
+)
+
+// panicRecovery turns a panicking handler into a 500 response instead of a
+// dead gateway, logging the stack and publishing a PANIC alert on the shared
+// Redis "service-alerts" channel.
+func panicRecovery(rdb *redis.Client) gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		stack := string(debug.Stack())
+		log.Printf("PANIC in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, recovered, stack)
+
+		lines := strings.Split(stack, "\n")
+		if len(lines) > 20 {
+			lines = lines[:20]
+		}
+		alert, _ := json.Marshal(gin.H{
+			"type":         "PANIC",
+			"service_name": "api-gateway",
+			"panic_value":  fmt.Sprintf("%v", recovered),
+			"stack":        strings.Join(lines, "\n"),
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		if err := rdb.Publish(context.Background(), "service-alerts", alert).Err(); err != nil {
+			log.Printf("Warning: could not publish panic alert: %v", err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// drainMiddleware tracks in-flight requests and refuses new ones while the
+// gateway is shutting down.
+func drainMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&draining) == 1 {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"success": false,
+				"message": "Gateway is shutting down",
+			})
+			return
+		}
+		atomic.AddInt64(&inFlightRequests, 1)
+		defer atomic.AddInt64(&inFlightRequests, -1)
+		c.Next()
 	}
-	return strings.TrimSpace(strings.ReplaceAll(fmt.Sprintf("%v", val), "<nil>", ""))
 }
 
 func main() {
 	_ = godotenv.Load()
 
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "redis:6379",
-	})
-	r := gin.Default()
+	// Sandbox runs share the backing services' isolation: Redis DB 1 and
+	// gin's test mode.
+	redisOpts := &redis.Options{Addr: "redis:6379"}
+	if os.Getenv("SANDBOX_MODE") == "true" {
+		redisOpts.DB = 1
+		gin.SetMode(gin.TestMode)
+	}
+	rdb := redis.NewClient(redisOpts)
+	r := gin.New()
 
 	// Global middlewares
+	r.Use(gin.Logger(), panicRecovery(rdb))
+	r.Use(drainMiddleware())
 	r.Use(middleware.RateLimitMiddleware(rdb))
 	r.Use(func(c *gin.Context) {
 		c.Header("X-Frame-Options", "DENY")
@@ -78,10 +151,30 @@ func main() {
 		c.Next()
 	})
 
+	// CORS preflights for every path, including the authenticated groups
+	r.OPTIONS("/*path", corsHandler)
+
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
+	// Machine-readable description of everything the gateway exposes,
+	// generated from the registered routes. Unprotected by design.
+	r.GET("/openapi.json", openapiHandler(r))
+
+	// Kubernetes-style probes: liveness only confirms the process responds,
+	// readiness verifies required dependencies (Redis) are reachable.
+	r.GET("/health/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	r.GET("/health/ready", func(c *gin.Context) {
+		if err := rdb.Ping(c).Err(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "redis": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
 	//----------------------------------------------------------------
 	//  Authentication
 	//   e.g. /authentication/register/customer
@@ -91,8 +184,20 @@ func main() {
 	authGroup := r.Group("/authentication")
 	{
 		authProxy := newReverseProxy(services["auth"].URL, "/authentication")
-		authGroup.POST("/register", authProxy)
-		authGroup.POST("/login", authProxy)
+		loginLimiter := middleware.LoginRateLimitMiddleware(rdb)
+		authGroup.POST("/register", loginLimiter, authProxy)
+		authGroup.POST("/login", loginLimiter, authProxy)
+		authGroup.GET("/validateToken", authProxy)
+		authGroup.GET("/listSessions", authProxy)
+		authGroup.DELETE("/sessions/:jti", authProxy)
+		authGroup.GET("/admin/listUsers", authProxy)
+		authGroup.GET("/admin/getUser/:id", authProxy)
+		authGroup.PATCH("/admin/updateUserType", authProxy)
+		authGroup.DELETE("/admin/deleteUser/:id", authProxy)
+		authGroup.GET("/admin/loginHistory/:user_id", authProxy)
+		authGroup.GET("/admin/bcryptStats", authProxy)
+		authGroup.GET("/notifications/preferences", authProxy)
+		authGroup.PUT("/notifications/preferences", authProxy)
 	}
 
 	//----------------------------------------------------------------
@@ -106,6 +211,13 @@ func main() {
 		setupProxy := newReverseProxy(services["order"].URL, "")
 		setupGroup.POST("/createStock", setupProxy)
 		setupGroup.POST("/addStockToUser", setupProxy)
+		setupGroup.PATCH("/updateStockName", setupProxy)
+		setupGroup.GET("/getStocksByCompany", setupProxy)
+		setupGroup.GET("/getStocksBySector", setupProxy)
+		setupGroup.GET("/getStocksByExchange", setupProxy)
+
+		walletSetupProxy := newReverseProxy(services["wallet"].URL, "")
+		setupGroup.GET("/searchStocks", walletSetupProxy)
 	}
 
 	//----------------------------------------------------------------
@@ -113,12 +225,32 @@ func main() {
 	//   e.g. /engine/placeStockOrder
 	//        /engine/cancelStockTransaction
 	//----------------------------------------------------------------
+	// Public trade tape — no auth, served by order-history
+	r.GET("/engine/tradeHistory", newReverseProxy(services["history"].URL, ""))
+
 	engineGroup := r.Group("/engine")
 	engineGroup.Use(middleware.AuthMiddleware())
 	{
 		engineProxy := newReverseProxy(services["order"].URL, "")
 		engineGroup.POST("/placeStockOrder", engineProxy)
+		engineGroup.POST("/bulkPlaceOrders", engineProxy)
 		engineGroup.POST("/cancelStockTransaction", engineProxy)
+		engineGroup.POST("/giftStock", engineProxy)
+		engineGroup.POST("/setMarketMakerSpread", engineProxy)
+		engineGroup.POST("/bulkCancelOrders", engineProxy)
+		engineGroup.GET("/searchOrders", engineProxy)
+		engineGroup.GET("/getFilledOrders", engineProxy)
+		engineGroup.GET("/getCancelableOrders", engineProxy)
+		engineGroup.GET("/getActiveOrderSummary", engineProxy)
+
+		historyEngineProxy := newReverseProxy(services["history"].URL, "")
+		engineGroup.GET("/getStockStats", historyEngineProxy)
+
+		matchingProxy := newReverseProxy(services["matching"].URL, "")
+		engineGroup.GET("/getBestQuote", matchingProxy)
+		engineGroup.GET("/getMarketDepth", matchingProxy)
+		engineGroup.GET("/getPendingOrderCount", matchingProxy)
+		engineGroup.GET("/watchStock", matchingProxy)
 	}
 
 	//----------------------------------------------------------------
@@ -130,9 +262,16 @@ func main() {
 	{
 		walletProxy := newReverseProxy(services["wallet"].URL, "/transaction")
 		transaction.POST("/addMoneyToWallet", walletProxy)
+		transaction.POST("/transferBetweenAccounts", walletProxy)
 		transaction.GET("/getWalletBalance", walletProxy)
 		transaction.GET("/getWalletTransactions", walletProxy)
 		transaction.GET("/getStockPortfolio", walletProxy)
+		transaction.GET("/getNetWorth", walletProxy)
+
+		historyProxy := newReverseProxy(services["history"].URL, "")
+		transaction.GET("/getRecentActivity", historyProxy)
+		transaction.GET("/getStockTransactionsByParent", historyProxy)
+		transaction.GET("/getTransactionTree", historyProxy)
 	}
 
 	//----------------------------------------------------------------
@@ -149,8 +288,37 @@ func main() {
 	if port == "" {
 		port = "8000"
 	}
-	log.Printf("API Gateway starting on port %s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Error starting server: %v", err)
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	go func() {
+		log.Printf("API Gateway starting on port %s", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Error starting server: %v", err)
+		}
+	}()
+
+	// On SIGTERM/SIGINT, stop accepting new requests and give in-flight
+	// proxy requests up to 30 seconds to drain.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGTERM, syscall.SIGINT)
+	<-quit
+
+	atomic.StoreInt32(&draining, 1)
+	log.Printf("Shutdown signal received, draining %d in-flight requests", atomic.LoadInt64(&inFlightRequests))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Forced shutdown with %d requests still in flight: %v", atomic.LoadInt64(&inFlightRequests), err)
+	} else {
+		log.Println("All in-flight requests drained, server stopped")
+	}
+
+	if err := rdb.Close(); err != nil {
+		log.Printf("Error closing Redis client: %v", err)
 	}
 }
@@ -1,8 +1,11 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"strconv"
@@ -74,6 +77,22 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// retryAfterSeconds computes how long a limited caller should wait: the time
+// until the stored window expiry, falling back to the full window when no
+// expiry is recorded. Never less than one second.
+func retryAfterSeconds(ctx context.Context, rdb *redis.Client, expiryKey string, window time.Duration) int64 {
+	retryAfter := int64(window / time.Second)
+	if stored, err := rdb.Get(ctx, expiryKey).Int64(); err == nil {
+		if remaining := stored - time.Now().Unix(); remaining > 0 {
+			retryAfter = remaining
+		}
+	}
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	return retryAfter
+}
+
 func RateLimitMiddleware(rdb *redis.Client) gin.HandlerFunc {
 	limit, _ := strconv.Atoi(os.Getenv("RATE_LIMIT"))
 	if limit == 0 {
@@ -93,6 +112,7 @@ func RateLimitMiddleware(rdb *redis.Client) gin.HandlerFunc {
 		} else {
 			identifier = fmt.Sprintf("ratelimit:ip:%s", c.ClientIP())
 		}
+		expiryKey := fmt.Sprintf("ratelimit_window_expiry:%s", identifier)
 
 		ctx := context.Background()
 
@@ -111,18 +131,28 @@ func RateLimitMiddleware(rdb *redis.Client) gin.HandlerFunc {
 		}
 
 		if count >= limit {
+			retryAfter := retryAfterSeconds(ctx, rdb, expiryKey, window)
+			c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"success": false,
-				"message": "Rate limit exceeded",
+				"data": gin.H{
+					"message":             "Rate limit exceeded",
+					"retry_after_seconds": retryAfter,
+				},
 			})
 			c.Abort()
 			return
 		}
 
+		// The reset time is fixed when the window opens; later requests in
+		// the same window must report that original expiry, not push it out.
+		reset := time.Now().Add(window).Unix()
+
 		pipe := rdb.Pipeline()
 		pipe.Incr(ctx, identifier)
 		if count == 0 {
 			pipe.Expire(ctx, identifier, window)
+			pipe.Set(ctx, expiryKey, reset, window)
 		}
 		_, err = pipe.Exec(ctx)
 		if err != nil {
@@ -134,9 +164,103 @@ func RateLimitMiddleware(rdb *redis.Client) gin.HandlerFunc {
 			return
 		}
 
+		if count > 0 {
+			if stored, err := rdb.Get(ctx, expiryKey).Int64(); err == nil {
+				reset = stored
+			}
+		}
+
 		c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
 		c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", limit-count-1))
-		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", time.Now().Add(window).Unix()))
+		c.Header("X-RateLimit-Reset", fmt.Sprintf("%d", reset))
+
+		c.Next()
+	}
+}
+
+// LoginRateLimitMiddleware applies a stricter per-username limit to the
+// credential endpoints so an attacker cannot brute-force one account from
+// many IPs. The request body is read to extract user_name and then restored
+// so the reverse proxy still forwards the original payload. Requests without
+// a parseable username fall through to the global IP-based limiter.
+func LoginRateLimitMiddleware(rdb *redis.Client) gin.HandlerFunc {
+	limit, _ := strconv.Atoi(os.Getenv("LOGIN_RATE_LIMIT"))
+	if limit == 0 {
+		limit = 5
+	}
+
+	windowStr := os.Getenv("LOGIN_RATE_LIMIT_WINDOW")
+	window, err := time.ParseDuration(windowStr)
+	if err != nil {
+		window = 15 * time.Minute
+	}
+
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"message": "Unable to read request body",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		var creds struct {
+			Username string `json:"user_name"`
+		}
+		if err := json.Unmarshal(body, &creds); err != nil || creds.Username == "" {
+			c.Next()
+			return
+		}
+
+		ctx := context.Background()
+		key := fmt.Sprintf("login_attempt:%s", creds.Username)
+
+		count, err := rdb.Get(ctx, key).Int()
+		if err != nil && err != redis.Nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Rate limiting error",
+			})
+			c.Abort()
+			return
+		}
+
+		if err == redis.Nil {
+			count = 0
+		}
+
+		if count >= limit {
+			// The attempt counter's TTL is the window expiry here; there is
+			// no separate expiry key for login limiting.
+			retryAfter := int64(window / time.Second)
+			if ttl, err := rdb.TTL(ctx, key).Result(); err == nil && ttl > 0 {
+				retryAfter = int64((ttl + time.Second - 1) / time.Second)
+			}
+			c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"success": false,
+				"message": "Too many login attempts, try again later",
+			})
+			c.Abort()
+			return
+		}
+
+		pipe := rdb.Pipeline()
+		pipe.Incr(ctx, key)
+		if count == 0 {
+			pipe.Expire(ctx, key, window)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"message": "Rate limiting error",
+			})
+			c.Abort()
+			return
+		}
 
 		c.Next()
 	}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClaimString renders a JWT claim value as a header-safe string for the
+// X-User-ID / X-User-Type headers forwarded to the backend services.
+// jwt.MapClaims decodes JSON numbers as float64, so user_id/user_type arrive
+// as a string or a float64 depending on how the token was minted. Floats need
+// explicit formatting: %v falls back to %g, which renders IDs of a million
+// and up in scientific notation (1e+06) and would corrupt the header.
+func ClaimString(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(fmt.Sprintf("%v", val), "<nil>", ""))
+}
@@ -0,0 +1,28 @@
+package middleware
+
+import "testing"
+
+// The proxied services parse X-User-ID / X-User-Type as plain integers, so
+// numeric claims must never come out in scientific notation.
+func TestClaimStringHeaderFormat(t *testing.T) {
+	cases := []struct {
+		name string
+		in   interface{}
+		want string
+	}{
+		{"string claim passes through", "COMPANY", "COMPANY"},
+		{"small float64 ID", float64(7), "7"},
+		{"million-range float64 ID stays plain", float64(1000000), "1000000"},
+		{"large float64 ID stays plain", float64(123456789), "123456789"},
+		{"fractional float64", 2.5, "2.5"},
+		{"integer claim", 3, "3"},
+		{"nil claim becomes empty", nil, ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ClaimString(tc.in); got != tc.want {
+				t.Fatalf("ClaimString(%v) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
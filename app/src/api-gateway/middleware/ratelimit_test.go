@@ -0,0 +1,72 @@
+package middleware
+
+// Rate limiter tests run against an in-process miniredis, so they need no
+// running Redis.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func rateLimitedRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(RateLimitMiddleware(rdb))
+	r.GET("/ping", func(c *gin.Context) {
+		c.String(http.StatusOK, "pong")
+	})
+	return r
+}
+
+// The reset header must report when the existing window expires, so rapid
+// requests inside one window all see the same value.
+func TestRateLimitResetHeaderStableWithinWindow(t *testing.T) {
+	r := rateLimitedRouter(t)
+
+	var first string
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i, w.Code)
+		}
+		reset := w.Header().Get("X-RateLimit-Reset")
+		if reset == "" {
+			t.Fatalf("request %d: missing X-RateLimit-Reset header", i)
+		}
+		if i == 0 {
+			first = reset
+			continue
+		}
+		if reset != first {
+			t.Fatalf("request %d: X-RateLimit-Reset = %s, want %s (unchanged)", i, reset, first)
+		}
+	}
+}
+
+func TestRateLimitRemainingDecrements(t *testing.T) {
+	r := rateLimitedRouter(t)
+
+	want := []string{"99", "98", "97"}
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.ServeHTTP(w, req)
+
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != want[i] {
+			t.Fatalf("request %d: X-RateLimit-Remaining = %s, want %s", i, got, want[i])
+		}
+	}
+}
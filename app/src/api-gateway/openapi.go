@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAPI 3.0 document generation. Rather than maintaining a spec file by hand
+// (which drifts the first week nobody updates it), the document is built from
+// the routes gin has actually registered, so /openapi.json always matches what
+// the gateway serves. Schemas for the common payload shapes are declared once
+// below; per-route request bodies live in the downstream services and are not
+// introspected here.
+
+// openapiOnce caches the generated document: routes never change after
+// startup, so the walk only needs to happen on the first request.
+var (
+	openapiOnce sync.Once
+	openapiDoc  gin.H
+)
+
+// requiresAuth reports whether a path sits behind AuthMiddleware. This
+// mirrors the group layout in main: /setup, /transaction and /engine are
+// authenticated, except the public trade tape.
+func requiresAuth(path string) bool {
+	if path == "/engine/tradeHistory" {
+		return false
+	}
+	return strings.HasPrefix(path, "/setup") ||
+		strings.HasPrefix(path, "/transaction") ||
+		strings.HasPrefix(path, "/engine")
+}
+
+// openapiPath converts gin's :param placeholders to OpenAPI's {param} form
+// and returns the parameter names found along the way.
+func openapiPath(path string) (string, []string) {
+	parts := strings.Split(path, "/")
+	var params []string
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			name := strings.TrimPrefix(part, ":")
+			parts[i] = "{" + name + "}"
+			params = append(params, name)
+		}
+	}
+	return strings.Join(parts, "/"), params
+}
+
+// buildOpenAPISpec walks the registered routes and assembles the document.
+func buildOpenAPISpec(r *gin.Engine) gin.H {
+	paths := gin.H{}
+	for _, route := range r.Routes() {
+		// The wildcard CORS preflight and the spec itself are plumbing,
+		// not API surface.
+		if route.Method == http.MethodOptions || route.Path == "/openapi.json" {
+			continue
+		}
+
+		specPath, params := openapiPath(route.Path)
+		op := gin.H{
+			"summary": route.Method + " " + specPath,
+			"responses": gin.H{
+				"200": gin.H{
+					"description": "Success",
+					"content": gin.H{
+						"application/json": gin.H{
+							"schema": gin.H{"$ref": "#/components/schemas/Response"},
+						},
+					},
+				},
+			},
+		}
+		if len(params) > 0 {
+			var ps []gin.H
+			for _, name := range params {
+				ps = append(ps, gin.H{
+					"name":     name,
+					"in":       "path",
+					"required": true,
+					"schema":   gin.H{"type": "string"},
+				})
+			}
+			op["parameters"] = ps
+		}
+		if requiresAuth(route.Path) {
+			op["security"] = []gin.H{{"bearerAuth": []string{}}, {"apiKeyAuth": []string{}}}
+			op["responses"].(gin.H)["401"] = gin.H{"description": "Missing or invalid token"}
+		}
+
+		entry, ok := paths[specPath].(gin.H)
+		if !ok {
+			entry = gin.H{}
+			paths[specPath] = entry
+		}
+		entry[strings.ToLower(route.Method)] = op
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":       "Robust Banker API",
+			"description": "Gateway routes for the day-trading platform. All requests are proxied to the backing microservices.",
+			"version":     "1.0.0",
+		},
+		"paths": paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{
+					"type":         "http",
+					"scheme":       "bearer",
+					"bearerFormat": "JWT",
+				},
+				// JMeter suites send the raw JWT in a "token" header instead
+				// of an Authorization header; both are accepted.
+				"apiKeyAuth": gin.H{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "token",
+				},
+			},
+			"schemas": gin.H{
+				"Response": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"success": gin.H{"type": "boolean"},
+						"data":    gin.H{"nullable": true},
+						"message": gin.H{"type": "string"},
+					},
+					"required": []string{"success"},
+				},
+				"LoginRequest": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"user_name": gin.H{"type": "string"},
+						"password":  gin.H{"type": "string"},
+					},
+					"required": []string{"user_name", "password"},
+				},
+				"Order": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"stock_id":    gin.H{"type": "integer"},
+						"stock_tx_id": gin.H{"type": "string", "format": "uuid"},
+						"is_buy":      gin.H{"type": "boolean"},
+						"order_type":  gin.H{"type": "string", "enum": []string{"MARKET", "LIMIT"}},
+						"quantity":    gin.H{"type": "integer"},
+						"price":       gin.H{"type": "number"},
+						"order_status": gin.H{
+							"type": "string",
+							"enum": []string{"IN_PROGRESS", "PARTIALLY_COMPLETE", "COMPLETED", "CANCELLED", "EXPIRED"},
+						},
+					},
+				},
+				"Stock": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"stock_id":   gin.H{"type": "integer"},
+						"stock_name": gin.H{"type": "string"},
+						"quantity":   gin.H{"type": "integer"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// openapiHandler serves the cached spec, building it lazily so every route
+// registered in main — including ones added after this handler — is covered.
+func openapiHandler(r *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		openapiOnce.Do(func() {
+			openapiDoc = buildOpenAPISpec(r)
+		})
+		c.JSON(http.StatusOK, openapiDoc)
+	}
+}
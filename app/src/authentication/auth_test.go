@@ -0,0 +1,157 @@
+package main
+
+// Unit tests for JWT generation and the session auth middleware. These need
+// no database: bootstrap() is never called and JWT_SECRET is set directly.
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "unit-test-secret"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Setenv("JWT_SECRET", testSecret)
+	os.Exit(m.Run())
+}
+
+// parseClaims decodes a token string with the test secret.
+func parseClaims(t *testing.T, tokenString string) jwt.MapClaims {
+	t.Helper()
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(testSecret), nil
+	})
+	if err != nil {
+		t.Fatal("parsing token:", err)
+	}
+	if !token.Valid {
+		t.Fatal("token reported invalid")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		t.Fatal("token claims are not MapClaims")
+	}
+	return claims
+}
+
+// signToken builds a token with the given claims and secret, mirroring the
+// shape generateToken produces.
+func signToken(t *testing.T, claims jwt.MapClaims, secret string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	signed, err := token.SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal("signing token:", err)
+	}
+	return signed
+}
+
+func TestGenerateTokenRoundTrip(t *testing.T) {
+	tokenString, jti, expiresAt, err := generateToken(1, "alice", "CUSTOMER")
+	if err != nil {
+		t.Fatal("generateToken:", err)
+	}
+	if jti == "" {
+		t.Fatal("generateToken returned an empty jti")
+	}
+	if until := time.Until(expiresAt); until < 11*time.Hour || until > 13*time.Hour {
+		t.Fatalf("token expiry %v from now, want ~12h", until)
+	}
+
+	claims := parseClaims(t, tokenString)
+	if got := claims["user_id"].(float64); int(got) != 1 {
+		t.Fatalf("user_id claim = %v, want 1", got)
+	}
+	if got := claims["username"]; got != "alice" {
+		t.Fatalf("username claim = %v, want alice", got)
+	}
+	if got := claims["user_type"]; got != "CUSTOMER" {
+		t.Fatalf("user_type claim = %v, want CUSTOMER", got)
+	}
+	if got := claims["jti"]; got != jti {
+		t.Fatalf("jti claim = %v, want %s", got, jti)
+	}
+}
+
+// runMiddleware sends one request with the given headers through
+// sessionAuthRequired and reports whether the chain was aborted, along with
+// the context it ran in.
+func runMiddleware(t *testing.T, headers map[string]string) (aborted bool, ctx *gin.Context, w *httptest.ResponseRecorder) {
+	t.Helper()
+	w = httptest.NewRecorder()
+	ctx, _ = gin.CreateTestContext(w)
+	req := httptest.NewRequest(http.MethodGet, "/listSessions", nil)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	ctx.Request = req
+
+	sessionAuthRequired()(ctx)
+	return ctx.IsAborted(), ctx, w
+}
+
+func TestMiddlewareAcceptsValidToken(t *testing.T) {
+	tokenString, _, _, err := generateToken(7, "bob", "COMPANY")
+	if err != nil {
+		t.Fatal("generateToken:", err)
+	}
+
+	aborted, ctx, _ := runMiddleware(t, map[string]string{"Authorization": "Bearer " + tokenString})
+	if aborted {
+		t.Fatal("middleware aborted a valid token")
+	}
+	if got := ctx.GetInt("user_id"); got != 7 {
+		t.Fatalf("user_id in context = %d, want 7", got)
+	}
+	if got := ctx.GetString("user_type"); got != "COMPANY" {
+		t.Fatalf("user_type in context = %q, want COMPANY", got)
+	}
+}
+
+func TestMiddlewareRejectsMissingHeader(t *testing.T) {
+	aborted, _, w := runMiddleware(t, nil)
+	if !aborted {
+		t.Fatal("middleware did not abort without an Authorization header")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("response code = %d, want 401", w.Code)
+	}
+}
+
+func TestMiddlewareRejectsExpiredToken(t *testing.T) {
+	expired := signToken(t, jwt.MapClaims{
+		"user_id": 1,
+		"exp":     time.Now().Add(-time.Hour).Unix(),
+		"iat":     time.Now().Add(-2 * time.Hour).Unix(),
+	}, testSecret)
+
+	aborted, _, w := runMiddleware(t, map[string]string{"Authorization": "Bearer " + expired})
+	if !aborted {
+		t.Fatal("middleware did not abort an expired token")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("response code = %d, want 401", w.Code)
+	}
+}
+
+func TestMiddlewareRejectsWrongKey(t *testing.T) {
+	forged := signToken(t, jwt.MapClaims{
+		"user_id": 1,
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}, "some-other-secret")
+
+	aborted, _, w := runMiddleware(t, map[string]string{"Authorization": "Bearer " + forged})
+	if !aborted {
+		t.Fatal("middleware did not abort a token signed with the wrong key")
+	}
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("response code = %d, want 401", w.Code)
+	}
+}
@@ -2,16 +2,25 @@ package main // main backend server
 
 import (
 	// MODULES
-	"database/sql" // SQL database
-	"fmt"          // I/O
-	"log"          // logs errors and messages
-	"net/http"     // http requests
-	"os"           // read environment variables
-	"time"         // time-related operations
+	"bytes"         // request body buffers
+	"context"       // Redis operations
+	"crypto/sha256" // migration file checksums
+	"database/sql"  // SQL database
+	"encoding/json" // inter-service payloads
+	"fmt"           // I/O
+	"log"           // logs errors and messages
+	"net/http"      // http requests
+	"os"            // read environment variables
+	"runtime/debug" // panic stack traces
+	"strconv"       // query/path parameter parsing
+	"strings"       // header parsing
+	"time"          // time-related operations
 
 	// LIBRARIES
 	"github.com/gin-gonic/gin"     // Gin framework for handling HTTP requests
+	"github.com/go-redis/redis/v8" // validated-token cache
 	"github.com/golang-jwt/jwt/v5" // JWT authentication
+	"github.com/google/uuid"       // token IDs (jti)
 	"github.com/joho/godotenv"     // environment variables
 	_ "github.com/lib/pq"          // PostgreSQL database driver
 	"golang.org/x/crypto/bcrypt"   // Hash and Verify passwords securely
@@ -24,6 +33,7 @@ type User struct {
 	Username string `json:"user_name"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	UserType string `json:"user_type"`
 }
 
 type LoginRequest struct {
@@ -35,6 +45,10 @@ type RegisterRequest struct {
 	Username string `json:"user_name"`
 	Password string `json:"password"`
 	Name     string `json:"name"`
+	// UserType discriminates CUSTOMER and COMPANY registrations; empty means
+	// CUSTOMER. CompanyName is required for (and only used by) COMPANY.
+	UserType    string `json:"user_type"`
+	CompanyName string `json:"company_name"`
 }
 
 type Token struct {
@@ -50,6 +64,31 @@ type Response struct {
 // SET UP
 var db *sql.DB
 
+var redisClient *redis.Client
+
+// initRedis connects the validated-token cache. Redis being unreachable only
+// disables caching; token validation itself keeps working.
+// sandboxMode reports whether the service runs isolated from production
+// data: a sandbox_-prefixed database, Redis DB 1, and gin's test mode.
+func sandboxMode() bool {
+	return os.Getenv("SANDBOX_MODE") == "true"
+}
+
+func initRedis() {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "redis:6379"
+	}
+	opts := &redis.Options{Addr: addr}
+	if sandboxMode() {
+		opts.DB = 1
+	}
+	redisClient = redis.NewClient(opts)
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Println("Warning: could not reach Redis at", addr, "-", err)
+	}
+}
+
 func buildDatabaseURL() string {
 	host := os.Getenv("DB_HOST")
 	port := os.Getenv("DB_PORT")
@@ -57,6 +96,9 @@ func buildDatabaseURL() string {
 	password := os.Getenv("DB_PASSWORD")
 	dbname := os.Getenv("DB_NAME")
 	sslmode := os.Getenv("DB_SSLMODE")
+	if sandboxMode() {
+		dbname = "sandbox_" + dbname
+	}
 
 	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
@@ -87,59 +129,791 @@ func initDB() error {
 }
 
 func applyMigrations() error {
-	migration, err := os.ReadFile("migrations/001_create_users_table.sql")
+	// Ledger of applied migration files and their content checksums. If a
+	// previously applied file changes on disk, the schema no longer matches
+	// what the file describes, so startup is refused - the same behavior as
+	// Flyway and Liquibase.
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			file_name VARCHAR(255) PRIMARY KEY,
+			file_checksum TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
 	if err != nil {
-		return fmt.Errorf("error reading migration file: %v", err)
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
 	}
 
-	// Execute migration
-	_, err = db.Exec(string(migration))
-	if err != nil {
-		return fmt.Errorf("error applying migration: %v", err)
+	migrationFiles := []string{
+		"migrations/001_create_users_table.sql",
+		"migrations/002_create_sessions_table.sql",
+		"migrations/003_user_type.sql",
+		"migrations/004_login_events.sql",
+		"migrations/005_notification_prefs.sql",
+	}
+
+	for _, file := range migrationFiles {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("error reading migration file %s: %v", file, err)
+		}
+		checksum := fmt.Sprintf("%x", sha256.Sum256(migration))
+
+		var stored string
+		err = db.QueryRow(
+			`SELECT file_checksum FROM schema_migrations WHERE file_name = $1`, file,
+		).Scan(&stored)
+		switch {
+		case err == sql.ErrNoRows:
+			// First run for this file; applied below and then recorded.
+		case err != nil:
+			return fmt.Errorf("error reading migration ledger for %s: %v", file, err)
+		case stored != checksum:
+			return fmt.Errorf("FATAL: migration %s has changed since it was applied (checksum %s, recorded %s); refusing to start", file, checksum, stored)
+		}
+
+		// Migrations are idempotent (IF NOT EXISTS guards), so re-executing
+		// a verified file on every startup is safe and keeps prior behavior.
+		if _, err := db.Exec(string(migration)); err != nil {
+			return fmt.Errorf("error applying migration %s: %v", file, err)
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO schema_migrations (file_name, file_checksum)
+			VALUES ($1, $2)
+			ON CONFLICT (file_name) DO NOTHING
+		`, file, checksum)
+		if err != nil {
+			return fmt.Errorf("error recording migration %s in ledger: %v", file, err)
+		}
 	}
 
 	log.Println("Database migrations applied successfully")
 	return nil
 }
 
-func init() {
+// bootstrap loads configuration and connects to Postgres. Called from main
+// rather than init so tests exercising the token logic need no database.
+// bcryptCost is the work factor for password hashing. Overridable via
+// BCRYPT_COST so the cost can be raised as hardware gets faster without a
+// code change; see initBcryptCost.
+var bcryptCost = bcrypt.DefaultCost
+
+// initBcryptCost parses BCRYPT_COST at startup. An out-of-range or
+// non-numeric value is fatal rather than silently clamped - a typo'd cost
+// must not quietly weaken (or wedge) every new password hash.
+func initBcryptCost() {
+	raw := os.Getenv("BCRYPT_COST")
+	if raw == "" {
+		return
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < bcrypt.MinCost || n > bcrypt.MaxCost {
+		log.Fatalf("BCRYPT_COST must be an integer between %d and %d, got %q", bcrypt.MinCost, bcrypt.MaxCost, raw)
+	}
+	bcryptCost = n
+}
+
+func bootstrap() {
 	// Load .env file and set the environment variables
 	if err := godotenv.Load(); err != nil {
 		log.Println("Warning: .env file not found")
 	}
 
+	initBcryptCost()
+
 	// Initialize database connection, read environment variables
 	if err := initDB(); err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 }
 
-func generateToken(userID int, username string) (string, error) {
+func generateToken(userID int, username, userType string) (string, string, time.Time, error) {
+	jti := uuid.NewString()
+	expiresAt := time.Now().Add(time.Hour * 12) // 12 hour expiration
+
 	// Generate token for authenticated user (successfully log in)
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  userID,
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * 12).Unix(), // 12 hour expiration
-		"iat":      time.Now().Unix(),                     // issued time
+		"user_id":   userID,
+		"username":  username,
+		"user_type": userType,
+		"jti":       jti, // token ID so sessions can be listed/revoked
+		"exp":       expiresAt.Unix(),
+		"iat":       time.Now().Unix(), // issued time
 	})
 
 	// Sign "token" using JWT_SECRET key from environment variables
 	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 	if err != nil {
-		return "", err
+		return "", "", time.Time{}, err
+	}
+
+	return tokenString, jti, expiresAt, nil
+}
+
+// recordSession stores the issued token's jti together with where the login
+// came from, so the user can later list and revoke individual sessions.
+func recordSession(c *gin.Context, jti string, userID int, expiresAt time.Time) {
+	_, err := db.ExecContext(c,
+		`INSERT INTO user_sessions (jti, user_id, expires_at, user_agent, ip_address)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		jti, userID, expiresAt, c.GetHeader("User-Agent"), c.ClientIP(),
+	)
+	if err != nil {
+		log.Println("Warning: could not record session:", err)
+	}
+}
+
+// sessionAuthRequired parses the caller's JWT (same header conventions as the
+// api-gateway middleware) and stores user_id and jti in the Gin context.
+func sessionAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		authHeader := c.GetHeader("Authorization")
+		tokenString := c.GetHeader("token")
+		if authHeader != "" {
+			parts := strings.Split(authHeader, " ")
+			if len(parts) == 2 && parts[0] == "Bearer" {
+				tokenString = parts[1]
+			}
+		}
+		if tokenString == "" {
+			c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "No authorization header found"})
+			c.Abort()
+			return
+		}
+
+		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, jwt.ErrSignatureInvalid
+			}
+			return []byte(os.Getenv("JWT_SECRET")), nil
+		})
+		if err != nil || !token.Valid {
+			c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Invalid token"})
+			c.Abort()
+			return
+		}
+
+		if claims, ok := token.Claims.(jwt.MapClaims); ok {
+			if userID, ok := claims["user_id"].(float64); ok {
+				c.Set("user_id", int(userID))
+			}
+			if jti, ok := claims["jti"].(string); ok {
+				c.Set("jti", jti)
+			}
+			if userType, ok := claims["user_type"].(string); ok {
+				c.Set("user_type", userType)
+			}
+		}
+		c.Next()
+	}
+}
+
+// TokenClaims is the decoded claim set returned by validateToken.
+type TokenClaims struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	UserType string `json:"user_type"`
+	Exp      int64  `json:"exp"`
+}
+
+// How long a validated token's claims stay cached. Revocations can lag by up
+// to this long for callers that rely on validateToken.
+const validatedTokenTTL = 60 * time.Second
+
+// validateTokenHandler lets other services independently verify a JWT they
+// received (defense in depth against a forged X-User-ID header). Validated
+// claims are cached per jti so hot callers do not re-parse and re-check the
+// session on every request.
+func validateTokenHandler(c *gin.Context) {
+	authHeader := c.GetHeader("Authorization")
+	tokenString := c.GetHeader("token")
+	if authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) == 2 && parts[0] == "Bearer" {
+			tokenString = parts[1]
+		}
+	}
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "No authorization header found"})
+		return
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, jwt.ErrSignatureInvalid
+		}
+		return []byte(os.Getenv("JWT_SECRET")), nil
+	})
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Invalid token"})
+		return
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Invalid token claims"})
+		return
+	}
+
+	jti, _ := claims["jti"].(string)
+	cacheKey := "validated_token:" + jti
+
+	if jti != "" {
+		if raw, err := redisClient.Get(c, cacheKey).Result(); err == nil {
+			var cached TokenClaims
+			if json.Unmarshal([]byte(raw), &cached) == nil {
+				c.JSON(http.StatusOK, Response{Success: true, Data: cached})
+				return
+			}
+		}
+	}
+
+	// Honour session revocation. Tokens issued before session recording
+	// existed have no row; those remain valid until they expire.
+	if jti != "" {
+		var revoked bool
+		err := db.QueryRowContext(c, `SELECT revoked FROM user_sessions WHERE jti = $1`, jti).Scan(&revoked)
+		if err == nil && revoked {
+			c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Token has been revoked"})
+			return
+		}
+	}
+
+	decoded := TokenClaims{}
+	if userID, ok := claims["user_id"].(float64); ok {
+		decoded.UserID = int(userID)
+	}
+	decoded.Username, _ = claims["username"].(string)
+	decoded.UserType, _ = claims["user_type"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		decoded.Exp = int64(exp)
+	}
+
+	if jti != "" {
+		if raw, err := json.Marshal(decoded); err == nil {
+			if err := redisClient.Set(c, cacheKey, raw, validatedTokenTTL).Err(); err != nil {
+				log.Println("Warning: could not cache validated token:", err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, Response{Success: true, Data: decoded})
+}
+
+type Session struct {
+	JTI       string    `json:"jti"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+}
+
+// listSessionsHandler returns the caller's non-expired, non-revoked sessions.
+func listSessionsHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	rows, err := db.QueryContext(c,
+		`SELECT jti, issued_at, expires_at, COALESCE(user_agent, ''), COALESCE(ip_address, '')
+		 FROM user_sessions
+		 WHERE user_id = $1 AND revoked = FALSE AND expires_at > CURRENT_TIMESTAMP
+		 ORDER BY issued_at DESC`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying sessions"})
+		return
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.JTI, &s.IssuedAt, &s.ExpiresAt, &s.UserAgent, &s.IPAddress); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error scanning sessions"})
+			return
+		}
+		sessions = append(sessions, s)
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: sessions})
+}
+
+// revokeSessionHandler marks one of the caller's sessions as revoked.
+func revokeSessionHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	jti := c.Param("jti")
+
+	result, err := db.ExecContext(c,
+		`UPDATE user_sessions SET revoked = TRUE WHERE jti = $1 AND user_id = $2`,
+		jti, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error revoking session"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "Session not found"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// adminRequired gates the /admin routes behind the ADMIN user type, which
+// carries the user-management permission. Must run after sessionAuthRequired.
+func adminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("user_type") != "ADMIN" {
+			c.JSON(http.StatusForbidden, Response{Success: false, Message: "Admin permission required"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminUser is the user view exposed to admins - everything except secrets.
+type AdminUser struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	UserType  string    `json:"user_type"`
+	CreatedAt time.Time `json:"created_at"`
+	Locked    bool      `json:"locked"`
+}
+
+// listUsersHandler enumerates users with pagination, optional user_type
+// filtering, and fuzzy username search.
+func listUsersHandler(c *gin.Context) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "50"))
+	if err != nil || pageSize < 1 {
+		pageSize = 50
+	}
+	if pageSize > 200 {
+		pageSize = 200
+	}
+
+	query := `SELECT id, username, user_type, created_at, locked FROM users`
+	var conditions []string
+	var args []interface{}
+
+	if userType := c.Query("user_type"); userType != "" {
+		if userType != "CUSTOMER" && userType != "COMPANY" && userType != "ADMIN" {
+			c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user_type filter"})
+			return
+		}
+		args = append(args, userType)
+		conditions = append(conditions, fmt.Sprintf("user_type = $%d", len(args)))
+	}
+	if search := c.Query("search"); search != "" {
+		args = append(args, "%"+search+"%")
+		conditions = append(conditions, fmt.Sprintf("username ILIKE $%d", len(args)))
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, pageSize, (page-1)*pageSize)
+	query += fmt.Sprintf(" ORDER BY id ASC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := db.QueryContext(c, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying users"})
+		return
+	}
+	defer rows.Close()
+
+	var users []AdminUser
+	for rows.Next() {
+		var u AdminUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.UserType, &u.CreatedAt, &u.Locked); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error scanning users"})
+			return
+		}
+		users = append(users, u)
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: users})
+}
+
+// getUserHandler returns a single user by ID.
+func getUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user ID"})
+		return
+	}
+
+	var u AdminUser
+	err = db.QueryRowContext(c,
+		`SELECT id, username, user_type, created_at, locked FROM users WHERE id = $1`, id,
+	).Scan(&u.ID, &u.Username, &u.UserType, &u.CreatedAt, &u.Locked)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying user"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: u})
+}
+
+// LoginEvent is one row of the login audit trail.
+type LoginEvent struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// loginHistoryHandler returns a user's 50 most recent login attempts.
+func loginHistoryHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user ID"})
+		return
+	}
+
+	rows, err := db.QueryContext(c,
+		`SELECT id, user_id, COALESCE(ip_address, ''), COALESCE(user_agent, ''), success, created_at
+		 FROM login_events
+		 WHERE user_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT 50`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying login events"})
+		return
+	}
+	defer rows.Close()
+
+	var events []LoginEvent
+	for rows.Next() {
+		var e LoginEvent
+		if err := rows.Scan(&e.ID, &e.UserID, &e.IPAddress, &e.UserAgent, &e.Success, &e.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error scanning login events"})
+			return
+		}
+		events = append(events, e)
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: events})
+}
+
+// bcryptStatsHandler hashes a throwaway password in a loop for roughly 100ms
+// and reports the throughput at the configured cost. Operators use this to
+// tune BCRYPT_COST: a cost where the service manages only a handful of hashes
+// per second will bottleneck registration and login under load.
+func bcryptStatsHandler(c *gin.Context) {
+	const sample = "bcrypt-benchmark-sample"
+	deadline := time.Now().Add(100 * time.Millisecond)
+	start := time.Now()
+	hashes := 0
+	for time.Now().Before(deadline) {
+		if _, err := bcrypt.GenerateFromPassword([]byte(sample), bcryptCost); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Benchmark failed: " + err.Error()})
+			return
+		}
+		hashes++
+	}
+	elapsed := time.Since(start).Seconds()
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{
+		"cost":              bcryptCost,
+		"hashes_per_second": float64(hashes) / elapsed,
+	}})
+}
+
+// notificationEventTypes are the event kinds a user can opt out of. They
+// mirror the events the trading pipeline pushes to clients.
+var notificationEventTypes = []string{
+	"TRADE_COMPLETED", "PARTIAL_FILL", "ORDER_EXPIRED", "DEPOSIT", "WITHDRAWAL",
+}
+
+func isNotificationEventType(t string) bool {
+	for _, known := range notificationEventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// loadNotificationPrefs returns the user's preference for every event type.
+// Event types without a stored row default to enabled.
+func loadNotificationPrefs(c *gin.Context, userID int) (map[string]bool, error) {
+	prefs := make(map[string]bool, len(notificationEventTypes))
+	for _, t := range notificationEventTypes {
+		prefs[t] = true
+	}
+	rows, err := db.QueryContext(c,
+		`SELECT event_type, enabled FROM user_notification_prefs WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var eventType string
+		var enabled bool
+		if err := rows.Scan(&eventType, &enabled); err != nil {
+			return nil, err
+		}
+		prefs[eventType] = enabled
+	}
+	return prefs, rows.Err()
+}
+
+func getNotificationPrefsHandler(c *gin.Context) {
+	prefs, err := loadNotificationPrefs(c, c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error reading preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: prefs})
+}
+
+// putNotificationPrefsHandler upserts the supplied subset of preferences and
+// returns the full effective map.
+func putNotificationPrefsHandler(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	var req struct {
+		Preferences map[string]bool `json:"preferences"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Preferences) == 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "preferences is required"})
+		return
+	}
+	for eventType := range req.Preferences {
+		if !isNotificationEventType(eventType) {
+			c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Unknown event type: " + eventType})
+			return
+		}
+	}
+	for eventType, enabled := range req.Preferences {
+		_, err := db.ExecContext(c, `
+			INSERT INTO user_notification_prefs (user_id, event_type, enabled)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, event_type) DO UPDATE
+			SET enabled = EXCLUDED.enabled, updated_at = CURRENT_TIMESTAMP`,
+			userID, eventType, enabled)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error saving preferences"})
+			return
+		}
+	}
+	prefs, err := loadNotificationPrefs(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error reading preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: prefs})
+}
+
+// notificationPrefsInternalHandler lets other services (matching) check a
+// user's preferences before pushing events to that user.
+func notificationPrefsInternalHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user_id"})
+		return
+	}
+	prefs, err := loadNotificationPrefs(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error reading preferences"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: prefs})
+}
+
+// Client for the cleanup calls made before a user is deleted.
+var interServiceClient = &http.Client{Timeout: 10 * time.Second}
+
+func serviceURL(envKey, fallback string) string {
+	if v := os.Getenv(envKey); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// zeroOutUserWallet asks wallet-portfolio to drain the user's wallet and
+// portfolio ahead of deletion.
+func zeroOutUserWallet(userID int) error {
+	payload, _ := json.Marshal(map[string]int{"user_id": userID})
+	url := serviceURL("WALLET_SERVICE_URL", "http://wallet-service:8083") + "/internal/zeroOutUser"
+	resp, err := interServiceClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("wallet service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// cancelUserOrders asks order-service to bulk-cancel the user's open orders.
+func cancelUserOrders(userID int) error {
+	url := serviceURL("ORDER_SERVICE_URL", "http://order-service:8081") + "/engine/bulkCancelOrders"
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-User-ID", strconv.Itoa(userID))
+	resp, err := interServiceClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("order service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deleteUserHandler removes an account entirely: open orders are cancelled,
+// the wallet and portfolio are zeroed out, then the user row and their
+// sessions are deleted in a single transaction. The audit log records only
+// the numeric user ID, never the username.
+func deleteUserHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user ID"})
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(c, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)`, id).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying user"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "User not found"})
+		return
+	}
+
+	// Clean up downstream state first so nothing settles into a deleted
+	// account. Either failure aborts the deletion.
+	if err := cancelUserOrders(id); err != nil {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Failed to cancel open orders: " + err.Error()})
+		return
+	}
+	if err := zeroOutUserWallet(id); err != nil {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Failed to zero out wallet: " + err.Error()})
+		return
+	}
+
+	tx, err := db.BeginTx(c, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error starting transaction"})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(c, `DELETE FROM user_sessions WHERE user_id = $1`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error deleting sessions"})
+		return
+	}
+	if _, err := tx.ExecContext(c, `DELETE FROM users WHERE id = $1`, id); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error deleting user"})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error committing deletion"})
+		return
 	}
 
-	return tokenString, nil
+	log.Printf("AUDIT USER_DELETED user_id=%d by_admin_id=%d", id, c.GetInt("user_id"))
+	c.Status(http.StatusNoContent)
+}
+
+// updateUserTypeHandler switches an account between CUSTOMER and COMPANY.
+// ADMIN is deliberately not assignable here - privilege changes are an
+// operational action, not an API one.
+func updateUserTypeHandler(c *gin.Context) {
+	var req struct {
+		UserID   int    `json:"user_id"`
+		UserType string `json:"user_type"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.UserType != "CUSTOMER" && req.UserType != "COMPANY" {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "user_type must be CUSTOMER or COMPANY"})
+		return
+	}
+
+	result, err := db.ExecContext(c,
+		`UPDATE users SET user_type = $1 WHERE id = $2 AND user_type IN ('CUSTOMER', 'COMPANY')`,
+		req.UserType, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error updating user type"})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "User not found or not eligible for a type change"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// registerSunset is the RFC 8594 Sunset date advertised on the deprecated
+// per-type registration aliases.
+const registerSunset = "Mon, 30 Nov 2026 00:00:00 GMT"
+
+// registerHandler creates a CUSTOMER or COMPANY account depending on the
+// user_type discriminator (empty means CUSTOMER). Companies register under
+// their company_name.
+// bindJSONStrict decodes the request body into dst, rejecting unknown fields
+// so a misspelled field name fails loudly instead of silently binding a zero
+// value. The returned error names the offending field.
+func bindJSONStrict(c *gin.Context, dst interface{}) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(dst)
 }
 
 func registerHandler(c *gin.Context) {
 	var req RegisterRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil, Message: "Invalid request body"})
+	if err := bindJSONStrict(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil, Message: "Invalid request body: " + err.Error()})
+		return
+	}
+	registerUser(c, req)
+}
+
+// deprecatedRegisterHandler serves the old /register/customer and
+// /register/company paths with the user type forced, flagging the response
+// as deprecated so clients move to the unified endpoint.
+func deprecatedRegisterHandler(userType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		c.Header("Sunset", registerSunset)
+		var req RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil, Message: "Invalid request body"})
+			return
+		}
+		req.UserType = userType
+		registerUser(c, req)
+	}
+}
+
+func registerUser(c *gin.Context, req RegisterRequest) {
+	if req.UserType == "" {
+		req.UserType = "CUSTOMER"
+	}
+	if req.UserType != "CUSTOMER" && req.UserType != "COMPANY" {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "user_type must be CUSTOMER or COMPANY"})
 		return
 	}
+	name := req.Name
+	if req.UserType == "COMPANY" {
+		if req.CompanyName == "" {
+			c.JSON(http.StatusBadRequest, Response{Success: false, Message: "company_name is required for COMPANY registration"})
+			return
+		}
+		name = req.CompanyName
+	}
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", req.Username).Scan(&exists)
+	err := db.QueryRowContext(c, "SELECT EXISTS (SELECT 1 FROM users WHERE username = $1)", req.Username).Scan(&exists)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{Success: false, Data: nil})
 		return
@@ -149,7 +923,7 @@ func registerHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil})
 		return
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error processing password"})
 		return
@@ -157,11 +931,12 @@ func registerHandler(c *gin.Context) {
 
 	// Insert new user
 	var userID int
-	err = db.QueryRow(
-		"INSERT INTO users (username, password, name) VALUES ($1, $2, $3) RETURNING id",
+	err = db.QueryRowContext(c,
+		"INSERT INTO users (username, password, name, user_type) VALUES ($1, $2, $3, $4) RETURNING id",
 		req.Username,
 		string(hashedPassword),
-		req.Name,
+		name,
+		req.UserType,
 	).Scan(&userID)
 
 	// Failed Registration
@@ -172,46 +947,212 @@ func registerHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
 }
 
+// recordLoginEvent writes one audit row per login attempt, successful or
+// not. A zero userID (unknown username) is stored as NULL. Auditing never
+// blocks the login itself, so failures are only logged.
+func recordLoginEvent(c *gin.Context, userID int, success bool) {
+	var id interface{}
+	if userID != 0 {
+		id = userID
+	}
+	_, err := db.ExecContext(c,
+		`INSERT INTO login_events (user_id, ip_address, user_agent, success)
+		 VALUES ($1, $2, $3, $4)`,
+		id, c.ClientIP(), c.GetHeader("User-Agent"), success,
+	)
+	if err != nil {
+		log.Println("Warning: could not record login event:", err)
+	}
+}
+
 func loginHandler(c *gin.Context) {
 	var req LoginRequest // same as req := RegisterRequest{}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil})
+	if err := bindJSONStrict(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil, Message: "Invalid request body: " + err.Error()})
 		return
 	}
 
 	var user User
-	err := db.QueryRow(
-		"SELECT id, username, password FROM users WHERE username = $1",
+	err := db.QueryRowContext(c,
+		"SELECT id, username, password, user_type FROM users WHERE username = $1",
 		req.Username,
-	).Scan(&user.ID, &user.Username, &user.Password) // extract retrieved data to "user" struct
+	).Scan(&user.ID, &user.Username, &user.Password, &user.UserType) // extract retrieved data to "user" struct
 
 	if err != nil {
+		recordLoginEvent(c, 0, false)
 		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil})
 		return
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		recordLoginEvent(c, user.ID, false)
 		c.JSON(http.StatusBadRequest, Response{Success: false, Data: nil})
 		return
 	}
+	recordLoginEvent(c, user.ID, true)
 
-	token, err := generateToken(user.ID, user.Username)
+	token, jti, expiresAt, err := generateToken(user.ID, user.Username, user.UserType)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{Success: false, Data: nil, Message: "Error generating token"})
 		return
 	}
+	recordSession(c, jti, user.ID, expiresAt)
 
 	c.JSON(http.StatusOK, Response{Success: true, Data: Token{SignedToken: token}})
 }
 
+// internalAuthRequired validates the shared X-Internal-Secret header on
+// service-to-service endpoints. When INTERNAL_API_SECRET is unset the check
+// is skipped, so local setups without the secret keep working.
+func internalAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := os.Getenv("INTERNAL_API_SECRET")
+		if secret != "" && c.GetHeader("X-Internal-Secret") != secret {
+			c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Invalid internal secret"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// userExistsHandler lets other services (e.g. order-service gifting) verify a
+// user ID without fetching profile data. Always returns 200 with an "exists"
+// flag; when the user exists their type is included so callers can gate
+// company-only actions.
+func userExistsHandler(c *gin.Context) {
+	userID := c.Query("user_id")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Missing user_id query parameter"})
+		return
+	}
+
+	var userType string
+	err := db.QueryRowContext(c, "SELECT user_type FROM users WHERE id = $1", userID).Scan(&userType)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, gin.H{"exists": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error checking user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exists": true, "user_type": userType})
+}
+
+// sandboxResetHandler truncates every table in the sandbox database so a CI
+// suite starts from a clean slate. The route is only registered in sandbox
+// mode; the in-handler guard is belt and braces.
+func sandboxResetHandler(c *gin.Context) {
+	if !sandboxMode() {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "Only available in sandbox mode"})
+		return
+	}
+
+	if _, err := db.ExecContext(c, "TRUNCATE TABLE login_events, user_sessions, users CASCADE"); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error truncating sandbox tables: " + err.Error()})
+		return
+	}
+
+	log.Println("Sandbox reset: truncated users, user_sessions, login_events")
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+func livenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+func readinessHandler(c *gin.Context) {
+	// Ready only if the users database is reachable
+	if err := db.Ping(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "database": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+}
+
+// panicRecovery turns a panicking handler into a 500 response instead of a
+// dead process, logging the stack and publishing a PANIC alert on the shared
+// Redis "service-alerts" channel.
+func panicRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		stack := string(debug.Stack())
+		log.Printf("PANIC in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, recovered, stack)
+
+		lines := strings.Split(stack, "\n")
+		if len(lines) > 20 {
+			lines = lines[:20]
+		}
+		alert, _ := json.Marshal(gin.H{
+			"type":         "PANIC",
+			"service_name": "authentication",
+			"panic_value":  fmt.Sprintf("%v", recovered),
+			"stack":        strings.Join(lines, "\n"),
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		if err := redisClient.Publish(context.Background(), "service-alerts", alert).Err(); err != nil {
+			log.Println("Warning: could not publish panic alert:", err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
 func main() {
-	r := gin.Default()
+	if sandboxMode() {
+		gin.SetMode(gin.TestMode)
+	}
+	bootstrap()
+	initRedis()
+	r := gin.New()
+	r.Use(gin.Logger(), panicRecovery())
 
 	// Authentication endpoints
 	r.POST("/register", registerHandler)
+	// Deprecated per-type aliases; the unified /register with a user_type
+	// discriminator replaces both.
+	r.POST("/register/customer", deprecatedRegisterHandler("CUSTOMER"))
+	r.POST("/register/company", deprecatedRegisterHandler("COMPANY"))
 	r.POST("/login", loginHandler)
 
+	// Token verification for other services (defense in depth)
+	r.GET("/validateToken", validateTokenHandler)
+
+	// Session management (requires a valid token)
+	r.GET("/listSessions", sessionAuthRequired(), listSessionsHandler)
+	r.DELETE("/sessions/:jti", sessionAuthRequired(), revokeSessionHandler)
+
+	// Notification preferences (requires a valid token)
+	notifications := r.Group("/notifications", sessionAuthRequired())
+	{
+		notifications.GET("/preferences", getNotificationPrefsHandler)
+		notifications.PUT("/preferences", putNotificationPrefsHandler)
+	}
+
+	// Admin user management (requires an ADMIN token)
+	admin := r.Group("/admin", sessionAuthRequired(), adminRequired())
+	{
+		admin.GET("/listUsers", listUsersHandler)
+		admin.GET("/getUser/:id", getUserHandler)
+		admin.PATCH("/updateUserType", updateUserTypeHandler)
+		admin.DELETE("/deleteUser/:id", deleteUserHandler)
+		admin.GET("/loginHistory/:user_id", loginHistoryHandler)
+		admin.GET("/bcryptStats", bcryptStatsHandler)
+	}
+
+	// Internal endpoints for other services (not exposed via the gateway)
+	r.GET("/internal/userExists", internalAuthRequired(), userExistsHandler)
+	r.GET("/internal/notificationPrefs", internalAuthRequired(), notificationPrefsInternalHandler)
+
+	if sandboxMode() {
+		r.DELETE("/internal/sandbox/reset", sandboxResetHandler)
+	}
+
+	// Kubernetes-style liveness/readiness probes
+	r.GET("/health/live", livenessHandler)
+	r.GET("/health/ready", readinessHandler)
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -0,0 +1,15 @@
+-- Create User Sessions Table
+-- One row per issued JWT, keyed by the token's jti claim. Used to list a
+-- user's active sessions and to revoke individual tokens.
+CREATE TABLE IF NOT EXISTS user_sessions (
+    jti VARCHAR(36) PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    issued_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    user_agent VARCHAR(255),
+    ip_address VARCHAR(45),
+    revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+-- Create index on user_id for listing a user's sessions
+CREATE INDEX IF NOT EXISTS idx_user_sessions_user_id ON user_sessions(user_id);
@@ -0,0 +1,8 @@
+-- Account classification and lockout flag.
+-- user_type distinguishes CUSTOMER, COMPANY and ADMIN accounts; ADMIN carries
+-- the user-management permission used by the /admin endpoints.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS user_type VARCHAR(20) NOT NULL DEFAULT 'CUSTOMER';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Create index for filtering users by type
+CREATE INDEX IF NOT EXISTS idx_users_user_type ON users(user_type);
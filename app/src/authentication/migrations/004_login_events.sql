@@ -0,0 +1,15 @@
+-- Create Login Events Table
+-- One row per login attempt, successful or not. Provides a security audit
+-- trail and the raw data for account lockout decisions. user_id is NULL when
+-- the attempted username does not exist.
+CREATE TABLE IF NOT EXISTS login_events (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    ip_address VARCHAR(45),
+    user_agent VARCHAR(255),
+    success BOOLEAN NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Create index for retrieving a user's recent attempts
+CREATE INDEX IF NOT EXISTS idx_login_events_user_time ON login_events(user_id, created_at DESC);
@@ -0,0 +1,11 @@
+-- Per-user notification preferences. One row per (user, event type); a
+-- missing row means the default, which is enabled. Event types mirror the
+-- order/wallet events pushed to clients: TRADE_COMPLETED, PARTIAL_FILL,
+-- ORDER_EXPIRED, DEPOSIT, WITHDRAWAL.
+CREATE TABLE IF NOT EXISTS user_notification_prefs (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, event_type)
+);
@@ -0,0 +1,2316 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/joho/godotenv"
+)
+
+// ----------------------------------------------------
+// Configuration
+// ----------------------------------------------------
+type Config struct {
+	RedisAddr       string
+	WalletURL       string
+	OrderHistoryURL string
+	OrderServiceURL string
+	// Authentication service base URL, used to read users' notification
+	// preferences before publishing per-user events.
+	AuthServiceURL string
+	Port           string
+	// Order book bounds, to keep a runaway client from exhausting memory
+	MaxOrdersPerSide int
+	MaxTotalEntries  int
+	// Trading fee taken out of the seller's proceeds on every fill.
+	// 0.001 = 0.1%. Set to 0 to disable fee collection.
+	TradeFeePct float64
+	// DLQ depth at which an ERROR alert is logged
+	DLQAlertThreshold int
+	// Wallet user ID that collected fees are credited to. Fees are still
+	// deducted from sellers when this is unset, just not credited anywhere.
+	PlatformAccountID int
+	// "postgres" enables durable book snapshots in CockroachDB; anything
+	// else disables snapshotting.
+	OrderBookBackend string
+	// Book events between snapshot rewrites when the backend is enabled
+	SnapshotEveryEvents int
+	// Oldest resting order age at which getPendingOrderCount logs an
+	// alert. 0 disables the alarm.
+	PendingAgeAlertSeconds int
+	// Seconds between ORDER_BOOK_STATS log lines
+	OrderStatsInterval int
+	// Absolute book imbalance above which a WARN is logged
+	ImbalanceAlertThreshold float64
+	// Shared secret sent as X-Internal-Secret on wallet calls so wallet-
+	// portfolio can reject other processes on the Docker network. Empty
+	// disables the header (local setups without the secret keep working).
+	InternalSecret string
+	// Seconds since the last processed order event before
+	// matchingServiceStatus answers 503. 0 disables the staleness check,
+	// which would otherwise trip on quiet markets.
+	HealthLagThreshold int
+	// Oldest a resting market order may get before matchOrders expires it
+	// instead of executing it at whatever price eventually shows up.
+	// 0 disables expiry.
+	MarketOrderMaxAge int
+}
+
+var cfg Config
+
+func loadConfig() {
+	_ = godotenv.Load()
+	cfg = Config{
+		RedisAddr:         envOr("REDIS_ADDR", "redis:6379"),
+		WalletURL:         envOr("WALLET_SERVICE_URL", "http://wallet-service:8083"),
+		OrderHistoryURL:   envOr("ORDER_HISTORY_SERVICE_URL", "http://order-history-service:8082"),
+		OrderServiceURL:   envOr("ORDER_SERVICE_URL", "http://order-service:8081"),
+		AuthServiceURL:    envOr("AUTH_SERVICE_URL", "http://auth-service:8080"),
+		Port:              envOr("PORT", "8084"),
+		MaxOrdersPerSide:  envOrInt("MAX_ORDERS_PER_SIDE_PER_STOCK", 10000),
+		MaxTotalEntries:   envOrInt("TOTAL_MAX_ORDER_BOOK_ENTRIES", 1000000),
+		TradeFeePct:       envOrFloat("TRADE_FEE_PCT", 0.001),
+		PlatformAccountID: envOrInt("PLATFORM_ACCOUNT_ID", 0),
+		DLQAlertThreshold: envOrInt("DLQ_ALERT_THRESHOLD", 100),
+
+		OrderBookBackend:    envOr("ORDER_BOOK_BACKEND", ""),
+		SnapshotEveryEvents: envOrInt("SNAPSHOT_EVERY_EVENTS", 1000),
+
+		PendingAgeAlertSeconds: envOrInt("PENDING_ORDER_AGE_ALERT_SECONDS", 0),
+		OrderStatsInterval:     envOrInt("ORDER_STATS_INTERVAL", 60),
+
+		ImbalanceAlertThreshold: envOrFloat("IMBALANCE_ALERT_THRESHOLD", 0.8),
+
+		InternalSecret: envOr("INTERNAL_API_SECRET", ""),
+
+		HealthLagThreshold: envOrInt("HEALTH_LAG_THRESHOLD", 0),
+
+		MarketOrderMaxAge: envOrInt("MARKET_ORDER_MAX_AGE_SECONDS", 30),
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envOrInt(key string, fallback int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		log.Printf("Warning: invalid value %q for %s, using default %d", v, key, fallback)
+	}
+	return fallback
+}
+
+func envOrFloat(key string, fallback float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 {
+			return f
+		}
+		log.Printf("Warning: invalid value %q for %s, using default %g", v, key, fallback)
+	}
+	return fallback
+}
+
+var redisClient *redis.Client
+
+// sandboxMode reports whether the service runs isolated from production
+// data: Redis DB 1, a sandbox snapshot database, and gin's test mode.
+func sandboxMode() bool {
+	return os.Getenv("SANDBOX_MODE") == "true"
+}
+
+func initRedis() {
+	opts := &redis.Options{Addr: cfg.RedisAddr}
+	if sandboxMode() {
+		opts.DB = 1
+	}
+	redisClient = redis.NewClient(opts)
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Println("Warning: could not reach Redis at", cfg.RedisAddr, "-", err)
+	}
+}
+
+// ----------------------------------------------------
+// Order book
+// ----------------------------------------------------
+
+// Order is the in-memory representation of an order in the book. Quantity is
+// the quantity currently visible to the matching loop; for iceberg orders
+// HiddenQty holds the undisclosed reserve that replenishes Quantity as fills
+// consume it.
+type Order struct {
+	StockID    int       `json:"stock_id"`
+	StockTxID  string    `json:"stock_tx_id"`
+	UserID     int       `json:"user_id"`
+	OrderType  string    `json:"order_type"`
+	IsBuy      bool      `json:"is_buy"`
+	Quantity   int       `json:"quantity"`
+	IcebergQty int       `json:"iceberg_qty"`
+	HiddenQty  int       `json:"hidden_quantity"`
+	Price      float64   `json:"price"`
+	Source     string    `json:"order_source"`
+	Created    time.Time `json:"created"`
+	// WalletTxID is the wallet transaction that settled this order's money
+	// leg, filled in after executeTrade so downstream records can link an
+	// order to its wallet movement.
+	WalletTxID string `json:"wallet_tx_id,omitempty"`
+}
+
+// isMarketMaker reports whether the order was posted by the order-service
+// market maker loop rather than a user.
+func (o *Order) isMarketMaker() bool {
+	return o.Source == "MARKET_MAKER"
+}
+
+func (o *Order) isMarket() bool {
+	return o.OrderType == "MARKET"
+}
+
+// totalRemaining is the full unfilled quantity including the hidden reserve.
+func (o *Order) totalRemaining() int {
+	return o.Quantity + o.HiddenQty
+}
+
+// normalizeIceberg splits an incoming order's quantity into the visible
+// portion and the hidden reserve when IcebergQty is set.
+func (o *Order) normalizeIceberg() {
+	if o.IcebergQty > 0 && o.Quantity > o.IcebergQty && o.HiddenQty == 0 {
+		o.HiddenQty = o.Quantity - o.IcebergQty
+		o.Quantity = o.IcebergQty
+	}
+}
+
+// replenishIceberg refills the visible quantity from the hidden reserve after
+// the visible portion has been fully consumed.
+func (o *Order) replenishIceberg() {
+	if o.Quantity == 0 && o.HiddenQty > 0 {
+		refill := o.IcebergQty
+		if refill <= 0 || refill > o.HiddenQty {
+			refill = o.HiddenQty
+		}
+		o.Quantity = refill
+		o.HiddenQty -= refill
+	}
+}
+
+// OrderBook holds the resting orders for one stock. Buys are kept best-first
+// (market orders, then highest price, then oldest); Sells are kept best-first
+// (market orders, then lowest price, then oldest).
+type OrderBook struct {
+	mu    sync.Mutex
+	Buys  []*Order
+	Sells []*Order
+}
+
+var (
+	booksMu sync.RWMutex
+	books   = make(map[int]*OrderBook)
+)
+
+// lastTradePrice holds the per-stock reference price: seeded from the stocks
+// table at startup and updated on every fill. Two crossing market orders have
+// no price of their own, so they execute at this reference; without one they
+// stay in the book rather than trading for free.
+var (
+	lastTradeMu    sync.RWMutex
+	lastTradePrice = make(map[int]float64)
+)
+
+func referencePrice(stockID int) (float64, bool) {
+	lastTradeMu.RLock()
+	defer lastTradeMu.RUnlock()
+	price, ok := lastTradePrice[stockID]
+	return price, ok && price > 0
+}
+
+func setReferencePrice(stockID int, price float64) {
+	if price <= 0 {
+		return
+	}
+	lastTradeMu.Lock()
+	lastTradePrice[stockID] = price
+	lastTradeMu.Unlock()
+}
+
+// loadReferencePrices seeds the reference prices from order-service's stocks
+// table. Failure is not fatal - market-vs-market fills for a stock simply
+// wait until its first priced trade.
+func loadReferencePrices() {
+	resp, err := http.Get(cfg.OrderServiceURL + "/internal/getStockPrices")
+	if err != nil {
+		log.Println("Warning: could not load reference prices:", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Println("Warning: reference price load returned status", resp.StatusCode)
+		return
+	}
+
+	var body struct {
+		Data []struct {
+			StockID     int     `json:"stock_id"`
+			MarketPrice float64 `json:"market_price"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		log.Println("Warning: could not decode reference prices:", err)
+		return
+	}
+	for _, s := range body.Data {
+		setReferencePrice(s.StockID, s.MarketPrice)
+	}
+	log.Printf("✅ Loaded %d reference prices from order-service", len(body.Data))
+}
+
+func getBook(stockID int) *OrderBook {
+	booksMu.RLock()
+	ob, ok := books[stockID]
+	booksMu.RUnlock()
+	if ok {
+		return ob
+	}
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	if ob, ok = books[stockID]; !ok {
+		ob = &OrderBook{}
+		books[stockID] = ob
+	}
+	return ob
+}
+
+func sortBuys(buys []*Order) {
+	sort.SliceStable(buys, func(i, j int) bool {
+		a, b := buys[i], buys[j]
+		if a.isMarket() != b.isMarket() {
+			return a.isMarket()
+		}
+		if a.Price != b.Price {
+			return a.Price > b.Price
+		}
+		return a.Created.Before(b.Created)
+	})
+}
+
+func sortSells(sells []*Order) {
+	sort.SliceStable(sells, func(i, j int) bool {
+		a, b := sells[i], sells[j]
+		if a.isMarket() != b.isMarket() {
+			return a.isMarket()
+		}
+		if a.Price != b.Price {
+			return a.Price < b.Price
+		}
+		return a.Created.Before(b.Created)
+	})
+}
+
+// totalBookEntries counts orders across every stock's book.
+var totalBookEntries int64
+
+var errBookFull = fmt.Errorf("order book depth limit reached")
+
+// addOrder places an order into the book for its stock. Returns errBookFull
+// when either the per-side-per-stock bound or the global bound is exceeded.
+func addOrder(order *Order) error {
+	order.normalizeIceberg()
+	ob := getBook(order.StockID)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	if atomic.LoadInt64(&totalBookEntries) >= int64(cfg.MaxTotalEntries) {
+		log.Printf("Warning: global order book limit (%d) reached, rejecting order %s", cfg.MaxTotalEntries, order.StockTxID)
+		return errBookFull
+	}
+
+	if order.IsBuy {
+		if len(ob.Buys) >= cfg.MaxOrdersPerSide {
+			log.Printf("Warning: buy side for stock %d is full (%d orders), rejecting order %s", order.StockID, cfg.MaxOrdersPerSide, order.StockTxID)
+			return errBookFull
+		}
+		ob.Buys = append(ob.Buys, order)
+		sortBuys(ob.Buys)
+	} else {
+		if len(ob.Sells) >= cfg.MaxOrdersPerSide {
+			log.Printf("Warning: sell side for stock %d is full (%d orders), rejecting order %s", order.StockID, cfg.MaxOrdersPerSide, order.StockTxID)
+			return errBookFull
+		}
+		ob.Sells = append(ob.Sells, order)
+		sortSells(ob.Sells)
+	}
+	atomic.AddInt64(&totalBookEntries, 1)
+	return nil
+}
+
+// removeLocked deletes an order from a book whose mutex the caller already
+// holds. Returns true if the order was found.
+func (ob *OrderBook) removeLocked(stockTxID string) bool {
+	for i, o := range ob.Buys {
+		if o.StockTxID == stockTxID {
+			ob.Buys = append(ob.Buys[:i], ob.Buys[i+1:]...)
+			atomic.AddInt64(&totalBookEntries, -1)
+			return true
+		}
+	}
+	for i, o := range ob.Sells {
+		if o.StockTxID == stockTxID {
+			ob.Sells = append(ob.Sells[:i], ob.Sells[i+1:]...)
+			atomic.AddInt64(&totalBookEntries, -1)
+			return true
+		}
+	}
+	return false
+}
+
+// removeOrder deletes an order from the book by its transaction ID. Returns
+// true if the order was found.
+func removeOrder(stockID int, stockTxID string) bool {
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return ob.removeLocked(stockTxID)
+}
+
+// getImbalance returns the book's visible volume imbalance,
+// (bid_vol - ask_vol) / (bid_vol + ask_vol): -1 is all sells, +1 all buys.
+// A strongly one-sided book is a leading indicator of price movement. An
+// empty book reports 0.
+func (ob *OrderBook) getImbalance() float64 {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	var bidVol, askVol int
+	for _, o := range ob.Buys {
+		bidVol += o.Quantity
+	}
+	for _, o := range ob.Sells {
+		askVol += o.Quantity
+	}
+	if bidVol+askVol == 0 {
+		return 0
+	}
+	return float64(bidVol-askVol) / float64(bidVol+askVol)
+}
+
+// isSelfTrade reports whether a pair belongs to the same user. Market maker
+// quotes are exempt - a market maker is allowed to trade against its own book.
+func isSelfTrade(buy, sell *Order) bool {
+	return buy.UserID == sell.UserID && !buy.isMarketMaker() && !sell.isMarketMaker()
+}
+
+// crosses reports whether the pair crosses on price alone.
+func crosses(buy, sell *Order) bool {
+	if buy.isMarket() && sell.isMarket() {
+		// Neither side carries a price; only match once a reference exists.
+		_, ok := referencePrice(buy.StockID)
+		return ok
+	}
+	if buy.isMarket() || sell.isMarket() {
+		return true
+	}
+	return buy.Price >= sell.Price
+}
+
+func canMatch(buy, sell *Order) bool {
+	return !isSelfTrade(buy, sell) && crosses(buy, sell)
+}
+
+// tradePrice resolves the execution price for a pair of crossing orders. The
+// resting limit sell sets the price; a limit buy against a market sell trades
+// at the buy limit.
+func tradePrice(buy, sell *Order) float64 {
+	if !sell.isMarket() {
+		return sell.Price
+	}
+	if !buy.isMarket() {
+		return buy.Price
+	}
+	// Market against market: canMatch guaranteed a reference price exists.
+	price, _ := referencePrice(buy.StockID)
+	return price
+}
+
+// expireStaleMarketOrdersLocked removes market orders older than
+// MARKET_ORDER_MAX_AGE_SECONDS from the front of both sides of a book whose
+// mutex the caller holds. Market orders sort ahead of every limit order, so
+// a stale one (typically stuck waiting for a reference price) would
+// otherwise sit at the top of the queue and eventually execute at whatever
+// price shows up, long after the user's intent went stale. Expired orders
+// are finalized as EXPIRED, which also publishes the order-status event.
+func (ob *OrderBook) expireStaleMarketOrdersLocked(stockID int) {
+	if cfg.MarketOrderMaxAge <= 0 {
+		return
+	}
+	maxAge := time.Duration(cfg.MarketOrderMaxAge) * time.Second
+	for _, side := range []*[]*Order{&ob.Buys, &ob.Sells} {
+		for len(*side) > 0 && (*side)[0].isMarket() && time.Since((*side)[0].Created) > maxAge {
+			stale := (*side)[0]
+			*side = (*side)[1:]
+			atomic.AddInt64(&totalBookEntries, -1)
+			log.Printf("⚠️ expiring stale market order %s (stock %d, age %s)",
+				stale.StockTxID, stockID, time.Since(stale.Created).Round(time.Second))
+			finalizeOrder(stale, "EXPIRED")
+			publishBookUpdate(stockID, map[string]interface{}{
+				"event": "ORDER_EXPIRED", "stock_tx_id": stale.StockTxID,
+			})
+		}
+	}
+}
+
+// matchOrders repeatedly crosses the best buy against the best sell for a
+// stock until the book no longer crosses.
+func matchOrders(stockID int) {
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	for {
+		ob.expireStaleMarketOrdersLocked(stockID)
+		if len(ob.Buys) == 0 || len(ob.Sells) == 0 {
+			break
+		}
+		buy, sell := ob.Buys[0], ob.Sells[0]
+		if isSelfTrade(buy, sell) && crosses(buy, sell) {
+			// Cancel-newest self-trade prevention. Leaving the pair resting
+			// would wedge the stock: both sides sit at the top of the book
+			// forever and nobody else's orders can cross past them. Cancelling
+			// the newer side keeps the older order's queue position and lets
+			// the pass continue.
+			newer, side := sell, &ob.Sells
+			if buy.Created.After(sell.Created) {
+				newer, side = buy, &ob.Buys
+			}
+			*side = (*side)[1:]
+			atomic.AddInt64(&totalBookEntries, -1)
+			log.Printf("⚠️ self-trade prevented for user %d on stock %d: cancelling newer order %s",
+				buy.UserID, stockID, newer.StockTxID)
+			finalizeOrder(newer, "CANCELLED")
+			publishBookUpdate(stockID, map[string]interface{}{
+				"event": "ORDER_CANCELLED", "stock_tx_id": newer.StockTxID,
+			})
+			continue
+		}
+		if !canMatch(buy, sell) {
+			break
+		}
+
+		qty := buy.Quantity
+		if sell.Quantity < qty {
+			qty = sell.Quantity
+		}
+		price := tradePrice(buy, sell)
+
+		if err := executeTrade(buy, sell, qty, price); err != nil {
+			log.Printf("❌ executeTrade failed for stock %d: %v", stockID, err)
+			atomic.AddInt64(&failedTradesInInterval, 1)
+			var te *transientError
+			if errors.As(err, &te) {
+				// Wallet down or 5xx: leave the book intact and let the next
+				// order event retry settlement.
+				break
+			}
+			// Permanent failure (insufficient funds, bad request): evict the
+			// order whose funds or holdings caused it, otherwise it rests at
+			// the top of the book and re-fails on every subsequent event.
+			var tf *tradeFailure
+			if errors.As(err, &tf) && ob.removeLocked(tf.order.StockTxID) {
+				log.Printf("⚠️ rejecting order %s after permanent settlement failure", tf.order.StockTxID)
+				finalizeOrder(tf.order, "REJECTED")
+				publishBookUpdate(stockID, map[string]interface{}{
+					"event": "ORDER_REJECTED", "stock_tx_id": tf.order.StockTxID,
+				})
+				continue
+			}
+			break
+		}
+		atomic.AddInt64(&matchesInInterval, 1)
+
+		buy.Quantity -= qty
+		sell.Quantity -= qty
+		buy.replenishIceberg()
+		sell.replenishIceberg()
+
+		setReferencePrice(stockID, price)
+		recordFill(buy, sell, qty, price)
+		publishBookUpdate(stockID, map[string]interface{}{
+			"event": "TRADE", "price": price, "qty": qty,
+		})
+
+		if buy.totalRemaining() == 0 {
+			ob.Buys = ob.Buys[1:]
+			atomic.AddInt64(&totalBookEntries, -1)
+			finalizeOrder(buy, "COMPLETED")
+		} else {
+			finalizeOrder(buy, "PARTIALLY_COMPLETE")
+		}
+		if sell.totalRemaining() == 0 {
+			ob.Sells = ob.Sells[1:]
+			atomic.AddInt64(&totalBookEntries, -1)
+			finalizeOrder(sell, "COMPLETED")
+		} else {
+			finalizeOrder(sell, "PARTIALLY_COMPLETE")
+		}
+	}
+}
+
+// Interval counters for the periodic stats line, reset on every log.
+var (
+	matchesInInterval      int64
+	failedTradesInInterval int64
+)
+
+// logOrderBookStats writes a one-line book summary every
+// ORDER_STATS_INTERVAL seconds so operators get passive observability
+// without polling an endpoint. The matched/failed counts cover only the
+// elapsed interval.
+func logOrderBookStats(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(cfg.OrderStatsInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			booksMu.RLock()
+			obs := make(map[int]*OrderBook, len(books))
+			for stockID, ob := range books {
+				obs[stockID] = ob
+			}
+			booksMu.RUnlock()
+
+			var activeStocks, buys, sells int
+			var ageSum float64
+			var oldest time.Time
+			now := time.Now()
+			for _, ob := range obs {
+				ob.mu.Lock()
+				if len(ob.Buys)+len(ob.Sells) > 0 {
+					activeStocks++
+				}
+				buys += len(ob.Buys)
+				sells += len(ob.Sells)
+				for _, o := range ob.Buys {
+					ageSum += now.Sub(o.Created).Seconds()
+					if oldest.IsZero() || o.Created.Before(oldest) {
+						oldest = o.Created
+					}
+				}
+				for _, o := range ob.Sells {
+					ageSum += now.Sub(o.Created).Seconds()
+					if oldest.IsZero() || o.Created.Before(oldest) {
+						oldest = o.Created
+					}
+				}
+				ob.mu.Unlock()
+			}
+
+			var avgAge, oldestAge float64
+			if buys+sells > 0 {
+				avgAge = ageSum / float64(buys+sells)
+				oldestAge = now.Sub(oldest).Seconds()
+			}
+			matched := atomic.SwapInt64(&matchesInInterval, 0)
+			failed := atomic.SwapInt64(&failedTradesInInterval, 0)
+			log.Printf("ORDER_BOOK_STATS stocks=%d buy_orders=%d sell_orders=%d avg_age_s=%.1f oldest_age_s=%.1f matched=%d failed_trades=%d",
+				activeStocks, buys, sells, avgAge, oldestAge, matched, failed)
+		}
+	}
+}
+
+// ----------------------------------------------------
+// Trade settlement (wallet + portfolio calls)
+// ----------------------------------------------------
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// doWalletCall POSTs a JSON payload to the wallet-portfolio service and
+// returns the response status code and raw body.
+func doWalletCall(path string, payload interface{}) (int, []byte, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, cfg.WalletURL+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.InternalSecret != "" {
+		req.Header.Set("X-Internal-Secret", cfg.InternalSecret)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, buf.Bytes(), nil
+}
+
+// transientError marks a failure worth retrying: a connection error or a 5xx
+// response. Everything else (insufficient funds, bad request) is permanent.
+type transientError struct{ err error }
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// tradeFailure pins a settlement error on the order whose funds or holdings
+// caused it, so matchOrders can evict that order when the failure is
+// permanent instead of leaving it wedged at the top of the book.
+type tradeFailure struct {
+	order *Order
+	err   error
+}
+
+func (e *tradeFailure) Error() string { return e.err.Error() }
+func (e *tradeFailure) Unwrap() error { return e.err }
+
+// Retries per wallet call on top of the initial attempt
+const walletMaxRetries = 3
+
+// retryableCall runs fn up to maxRetries extra times, retrying only transient
+// failures with exponential backoff plus ±20% jitter so synchronized retries
+// from concurrent fills do not hammer a recovering wallet service. Only safe
+// for calls the wallet side deduplicates or tolerates replaying.
+func retryableCall(fn func() error, maxRetries int) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		var te *transientError
+		if err == nil || !errors.As(err, &te) || attempt == maxRetries {
+			return err
+		}
+		backoffMs := 100 << attempt
+		sleep := time.Duration(float64(backoffMs)*(0.8+0.4*rand.Float64())) * time.Millisecond
+		log.Printf("⚠️ wallet call failed (attempt %d/%d), retrying in %s: %v",
+			attempt+1, maxRetries, sleep, err)
+		time.Sleep(sleep)
+	}
+}
+
+// walletCallOnce classifies one wallet call's outcome for retryableCall and
+// returns the response body on success so callers can read returned IDs.
+func walletCallOnce(path, label string, payload map[string]interface{}) ([]byte, error) {
+	status, body, err := doWalletCall(path, payload)
+	if err != nil {
+		return nil, &transientError{err}
+	}
+	if status >= http.StatusInternalServerError {
+		return nil, &transientError{fmt.Errorf("%s returned status %d: %s", label, status, body)}
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d: %s", label, status, body)
+	}
+	return body, nil
+}
+
+// parseWalletTxID pulls data.wallet_tx_id out of a wallet response body. An
+// empty result is not an error: not every wallet endpoint returns one.
+func parseWalletTxID(body []byte) string {
+	var resp struct {
+		Data struct {
+			WalletTxID string `json:"wallet_tx_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return ""
+	}
+	return resp.Data.WalletTxID
+}
+
+// callDeductMoney debits a user and returns the wallet_tx_id the wallet
+// service recorded for the debit.
+func callDeductMoney(userID int, amount float64) (string, error) {
+	var walletTxID string
+	err := retryableCall(func() error {
+		body, err := walletCallOnce("/internal/deductMoneyFromWallet", "deduct money",
+			map[string]interface{}{"user_id": userID, "amount": amount})
+		if err != nil {
+			return err
+		}
+		walletTxID = parseWalletTxID(body)
+		return nil
+	}, walletMaxRetries)
+	return walletTxID, err
+}
+
+// callAddMoney credits a user and returns the wallet_tx_id the wallet service
+// recorded for the credit.
+func callAddMoney(userID int, amount float64) (string, error) {
+	var walletTxID string
+	err := retryableCall(func() error {
+		body, err := walletCallOnce("/internal/addMoneyToWallet", "add money",
+			map[string]interface{}{"user_id": userID, "amount": amount})
+		if err != nil {
+			return err
+		}
+		walletTxID = parseWalletTxID(body)
+		return nil
+	}, walletMaxRetries)
+	return walletTxID, err
+}
+
+// tradeFee returns the platform fee owed on a fill of the given notional
+// cost, rounded down to the cent so the seller is never over-charged.
+func tradeFee(cost float64) float64 {
+	return math.Floor(cost*cfg.TradeFeePct*100) / 100
+}
+
+// collectTradeFee deducts the fee from the seller and credits it to the
+// platform account. Both legs are recorded as FEE wallet transactions. Fee
+// collection failures never fail the trade itself - the fill already
+// settled - so they are only logged.
+func collectTradeFee(sellUserID int, fee float64) {
+	status, body, err := doWalletCall("/internal/deductMoneyFromWallet",
+		map[string]interface{}{"user_id": sellUserID, "amount": fee, "tx_type": "FEE"})
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("deduct fee returned status %d: %s", status, body)
+	}
+	if err != nil {
+		log.Printf("❌ failed to collect fee %.2f from seller %d: %v", fee, sellUserID, err)
+		return
+	}
+	if cfg.PlatformAccountID == 0 {
+		return
+	}
+	status, body, err = doWalletCall("/internal/addMoneyToWallet",
+		map[string]interface{}{"user_id": cfg.PlatformAccountID, "amount": fee, "tx_type": "FEE"})
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("credit fee returned status %d: %s", status, body)
+	}
+	if err != nil {
+		log.Printf("❌ failed to credit fee %.2f to platform account %d: %v", fee, cfg.PlatformAccountID, err)
+	}
+}
+
+func callUpdatePortfolio(userID, stockID, quantity int) error {
+	return retryableCall(func() error {
+		_, err := walletCallOnce("/internal/updateStockPortfolio", "update portfolio",
+			map[string]interface{}{"user_id": userID, "stock_id": stockID, "quantity": quantity})
+		return err
+	}, walletMaxRetries)
+}
+
+// walletData is the inner payload of the wallet service's balance response:
+// {"success":true,"data":{"balance":1234.56}}.
+type walletData struct {
+	Balance float64 `json:"balance"`
+}
+
+// parseWalletBalance decodes a wallet balance response body. Data is kept as
+// raw JSON first so a missing or misshapen payload fails loudly instead of
+// silently reading zero.
+func parseWalletBalance(body []byte) (float64, error) {
+	var resp struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return 0, err
+	}
+	if !resp.Success || len(resp.Data) == 0 {
+		return 0, fmt.Errorf("unexpected wallet response shape")
+	}
+	var data walletData
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return 0, fmt.Errorf("unexpected wallet response shape: %w", err)
+	}
+	return data.Balance, nil
+}
+
+// estimatedCost returns the notional a buy order would need to settle in
+// full: limit orders use their limit price, market orders the current
+// reference price. ok is false when no price is known (a market order on a
+// stock that has never traded), in which case the funds check is skipped.
+func estimatedCost(order *Order) (float64, bool) {
+	qty := float64(order.totalRemaining())
+	if !order.isMarket() {
+		return order.Price * qty, true
+	}
+	price, ok := referencePrice(order.StockID)
+	if !ok {
+		return 0, false
+	}
+	return price * qty, true
+}
+
+// checkBuyerFunds pre-checks an incoming buy order against the buyer's wallet
+// balance, so an obviously unfundable order is rejected on arrival instead of
+// resting in the book until settlement fails. It fails open: the balance can
+// change before the order fills either way, and settlement re-checks funds
+// authoritatively, so a wallet hiccup must not block order intake.
+func checkBuyerFunds(order *Order) bool {
+	if !order.IsBuy {
+		return true
+	}
+	cost, ok := estimatedCost(order)
+	if !ok || cost <= 0 {
+		return true
+	}
+	balance, err := getUserBalance(order.UserID)
+	if err != nil {
+		log.Printf("⚠️ could not pre-check funds for order %s: %v", order.StockTxID, err)
+		return true
+	}
+	if balance < cost {
+		log.Printf("⚠️ rejecting order %s: buyer %d has %.2f, needs %.2f",
+			order.StockTxID, order.UserID, balance, cost)
+		return false
+	}
+	return true
+}
+
+// getUserBalance reads a user's wallet balance from the wallet service.
+func getUserBalance(userID int) (float64, error) {
+	resp, err := httpClient.Get(fmt.Sprintf("%s/internal/getWalletBalance?user_id=%d", cfg.WalletURL, userID))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("wallet service returned status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return parseWalletBalance(body)
+}
+
+// Compensation steps recorded against a failed trade. The step name tells the
+// retry path exactly which wallet call to replay.
+const (
+	stepRefundBuyer          = "REFUND_BUYER"
+	stepReturnSellerShares   = "RETURN_SELLER_SHARES"
+	stepCreditSellerProceeds = "CREDIT_SELLER_PROCEEDS"
+)
+
+// recordFailedCompensation persists a compensation step that could not be
+// completed, so an admin can replay it via /internal/admin/retryFailedTrade.
+func recordFailedCompensation(buy, sell *Order, qty int, price float64, step string, cause error) {
+	ft := map[string]interface{}{
+		"stock_tx_id":   buy.StockTxID,
+		"stock_id":      buy.StockID,
+		"buy_user_id":   buy.UserID,
+		"sell_user_id":  sell.UserID,
+		"quantity":      qty,
+		"price":         price,
+		"failed_step":   step,
+		"error_message": cause.Error(),
+	}
+	if err := postHistoryJSON("/internal/recordFailedTrade", ft); err != nil {
+		log.Printf("❌ failed to record failed compensation (%s for trade %s): %v", step, buy.StockTxID, err)
+	}
+}
+
+// executeTrade settles one fill: moves money from buyer to seller and shares
+// from seller to buyer, compensating earlier steps if a later one fails.
+// Compensation steps that themselves fail are recorded in order-history so
+// they can be replayed by an operator.
+func executeTrade(buy, sell *Order, qty int, price float64) error {
+	cost := float64(qty) * price
+
+	if cost > 0 {
+		walletTxID, err := callDeductMoney(buy.UserID, cost)
+		if err != nil {
+			return &tradeFailure{buy, fmt.Errorf("deducting buyer funds: %w", err)}
+		}
+		buy.WalletTxID = walletTxID
+	}
+	if err := callUpdatePortfolio(sell.UserID, sell.StockID, -qty); err != nil {
+		if cost > 0 {
+			if _, compErr := callAddMoney(buy.UserID, cost); compErr != nil {
+				log.Printf("❌ compensation failed (refund buyer %d): %v", buy.UserID, compErr)
+				recordFailedCompensation(buy, sell, qty, price, stepRefundBuyer, compErr)
+			}
+		}
+		return &tradeFailure{sell, fmt.Errorf("deducting seller shares: %w", err)}
+	}
+	if err := callUpdatePortfolio(buy.UserID, buy.StockID, qty); err != nil {
+		if compErr := callUpdatePortfolio(sell.UserID, sell.StockID, qty); compErr != nil {
+			log.Printf("❌ compensation failed (return seller shares %d): %v", sell.UserID, compErr)
+			recordFailedCompensation(buy, sell, qty, price, stepReturnSellerShares, compErr)
+		}
+		if cost > 0 {
+			if _, compErr := callAddMoney(buy.UserID, cost); compErr != nil {
+				log.Printf("❌ compensation failed (refund buyer %d): %v", buy.UserID, compErr)
+				recordFailedCompensation(buy, sell, qty, price, stepRefundBuyer, compErr)
+			}
+		}
+		return &tradeFailure{buy, fmt.Errorf("crediting buyer shares: %w", err)}
+	}
+	if cost > 0 {
+		walletTxID, err := callAddMoney(sell.UserID, cost)
+		if err != nil {
+			// Money already left the buyer; record for replay rather than
+			// unwind the whole trade at this point.
+			log.Printf("❌ failed to credit seller %d proceeds %.2f: %v", sell.UserID, cost, err)
+			recordFailedCompensation(buy, sell, qty, price, stepCreditSellerProceeds, err)
+			return nil
+		}
+		sell.WalletTxID = walletTxID
+		if fee := tradeFee(cost); fee > 0 {
+			collectTradeFee(sell.UserID, fee)
+		}
+	}
+	return nil
+}
+
+// failedTrade mirrors the row shape served by order-history.
+type failedTrade struct {
+	StockTxID  string  `json:"stock_tx_id"`
+	StockID    int     `json:"stock_id"`
+	BuyUserID  int     `json:"buy_user_id"`
+	SellUserID int     `json:"sell_user_id"`
+	Quantity   int     `json:"quantity"`
+	Price      float64 `json:"price"`
+	FailedStep string  `json:"failed_step"`
+}
+
+// replayCompensation re-issues the wallet call for one recorded failure.
+func replayCompensation(ft *failedTrade) error {
+	cost := float64(ft.Quantity) * ft.Price
+	switch ft.FailedStep {
+	case stepRefundBuyer:
+		_, err := callAddMoney(ft.BuyUserID, cost)
+		return err
+	case stepReturnSellerShares:
+		return callUpdatePortfolio(ft.SellUserID, ft.StockID, ft.Quantity)
+	case stepCreditSellerProceeds:
+		_, err := callAddMoney(ft.SellUserID, cost)
+		return err
+	default:
+		return fmt.Errorf("unknown failed step %q", ft.FailedStep)
+	}
+}
+
+// retryFailedTrade looks up an unresolved compensation failure in
+// order-history, replays the wallet call, and marks it resolved on success.
+func retryFailedTrade(stockTxID, failedStep string) error {
+	url := fmt.Sprintf("%s/internal/admin/failedTrade?stock_tx_id=%s&failed_step=%s",
+		cfg.OrderHistoryURL, stockTxID, failedStep)
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("looking up failed trade: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("no unresolved failure for trade %s step %s", stockTxID, failedStep)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("order-history returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data failedTrade `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("decoding failed trade: %w", err)
+	}
+
+	if err := replayCompensation(&body.Data); err != nil {
+		return fmt.Errorf("replaying %s: %w", failedStep, err)
+	}
+
+	resolve := map[string]interface{}{"stock_tx_id": stockTxID, "failed_step": failedStep}
+	if err := postJSON(cfg.OrderHistoryURL+"/internal/admin/resolveFailedTrade", resolve); err != nil {
+		return fmt.Errorf("marking trade resolved: %w", err)
+	}
+	log.Printf("✅ TRADE_COMPENSATION_RESOLVED trade=%s step=%s", stockTxID, failedStep)
+	return nil
+}
+
+// ----------------------------------------------------
+// Recording results (order-history + order-service)
+// ----------------------------------------------------
+func postJSON(url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// order-history availability tracking
+// ----------------------------------------------------------------------------
+
+// orderHistoryUp is 1 while order-history answers its health probe. While it
+// is 0, history writes are parked on a Redis list instead of being lost.
+var orderHistoryUp int32 = 1
+
+const orderHistoryBacklogKey = "order-history-backlog"
+
+// checkOrderHistoryHealth probes order-history's /health endpoint with a
+// 10-second deadline and updates the availability flag.
+func checkOrderHistoryHealth() bool {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(cfg.OrderHistoryURL + "/health")
+	up := err == nil && resp.StatusCode == http.StatusOK
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if up {
+		atomic.StoreInt32(&orderHistoryUp, 1)
+	} else {
+		atomic.StoreInt32(&orderHistoryUp, 0)
+		if err != nil {
+			log.Println("WARNING: order-history unreachable:", err)
+		} else {
+			log.Println("WARNING: order-history health returned status", resp.StatusCode)
+		}
+	}
+	return up
+}
+
+// queueHistoryWrite parks one order-history POST (path + payload) for replay
+// once the service comes back.
+func queueHistoryWrite(path string, payload map[string]interface{}) {
+	entry, err := json.Marshal(map[string]interface{}{
+		"path":      path,
+		"payload":   payload,
+		"queued_at": time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		return
+	}
+	if err := redisClient.RPush(context.Background(), orderHistoryBacklogKey, entry).Err(); err != nil {
+		log.Println("❌ failed to queue order-history write:", err)
+	}
+}
+
+// postHistoryJSON sends a write to order-history, queueing it instead when
+// the service is known (or found) to be down.
+func postHistoryJSON(path string, payload map[string]interface{}) error {
+	if atomic.LoadInt32(&orderHistoryUp) == 0 {
+		queueHistoryWrite(path, payload)
+		return nil
+	}
+	if err := postJSON(cfg.OrderHistoryURL+path, payload); err != nil {
+		atomic.StoreInt32(&orderHistoryUp, 0)
+		queueHistoryWrite(path, payload)
+		return err
+	}
+	return nil
+}
+
+// drainHistoryBacklog replays queued writes after order-history recovers.
+// Stops (and requeues) on the first failure so ordering is preserved.
+func drainHistoryBacklog() {
+	ctx := context.Background()
+	for {
+		raw, err := redisClient.LPop(ctx, orderHistoryBacklogKey).Result()
+		if err == redis.Nil {
+			return
+		}
+		if err != nil {
+			log.Println("❌ failed to read order-history backlog:", err)
+			return
+		}
+		var entry struct {
+			Path    string                 `json:"path"`
+			Payload map[string]interface{} `json:"payload"`
+		}
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil {
+			log.Println("❌ dropping malformed order-history backlog entry:", err)
+			continue
+		}
+		if err := postJSON(cfg.OrderHistoryURL+entry.Path, entry.Payload); err != nil {
+			atomic.StoreInt32(&orderHistoryUp, 0)
+			redisClient.LPush(ctx, orderHistoryBacklogKey, raw)
+			log.Println("❌ order-history backlog replay failed, will retry:", err)
+			return
+		}
+	}
+}
+
+// monitorOrderHistory re-checks order-history every 30 seconds and drains the
+// backlog whenever the service is up.
+func monitorOrderHistory(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if checkOrderHistoryHealth() {
+				drainHistoryBacklog()
+			}
+		}
+	}
+}
+
+// recordFill writes one executed trade to order-history, linked to the buyer
+// and seller order IDs.
+func recordFill(buy, sell *Order, qty int, price float64) {
+	// The aggressor is the later-arriving order; it crossed the spread
+	// against the resting side.
+	aggressor := "SELL"
+	if buy.Created.After(sell.Created) {
+		aggressor = "BUY"
+	}
+	tx := map[string]interface{}{
+		"stock_tx_id":        uuid.NewString(),
+		"parent_stock_tx_id": buy.StockTxID,
+		"stock_id":           fmt.Sprintf("%d", buy.StockID),
+		"order_status":       "COMPLETED",
+		"is_buy":             true,
+		"order_type":         buy.OrderType,
+		"stock_price":        price,
+		"quantity":           qty,
+		"buyer_id":           fmt.Sprintf("%d", buy.UserID),
+		"seller_id":          fmt.Sprintf("%d", sell.UserID),
+		"aggressor_side":     aggressor,
+	}
+	// Link the fill to the wallet movement that settled it (the buyer's
+	// debit; free fills have none).
+	if buy.WalletTxID != "" {
+		tx["wallet_tx_id"] = buy.WalletTxID
+	}
+	if err := postHistoryJSON("/internal/recordStockTransaction", tx); err != nil {
+		log.Println("❌ failed to record trade in order-history:", err)
+	}
+
+	// Keep the daily OHLCV summary up to date
+	md := map[string]interface{}{
+		"stock_id": buy.StockID,
+		"price":    price,
+		"quantity": qty,
+	}
+	if err := postHistoryJSON("/internal/recordMarketData", md); err != nil {
+		log.Println("❌ failed to record market data in order-history:", err)
+	}
+}
+
+// finalizeOrder pushes an order's new status and remaining quantities back to
+// order-service (Cassandra) and announces it on the order-status channel.
+func finalizeOrder(order *Order, status string) {
+	update := map[string]interface{}{
+		"stock_tx_id":      order.StockTxID,
+		"stock_id":         order.StockID,
+		"user_id":          order.UserID,
+		"order_type":       order.OrderType,
+		"is_buy":           order.IsBuy,
+		"created":          order.Created,
+		"order_status":     status,
+		"quantity":         order.totalRemaining(),
+		"visible_quantity": order.Quantity,
+		"hidden_quantity":  order.HiddenQty,
+	}
+	if order.WalletTxID != "" {
+		update["wallet_tx_id"] = order.WalletTxID
+	}
+	if err := postJSON(cfg.OrderServiceURL+"/internal/updateOrderStatus", update); err != nil {
+		log.Println("❌ failed to update order status in order-service:", err)
+	} else if status == "COMPLETED" || status == "CANCELLED" {
+		// Terminal orders get a Cassandra TTL so they expire instead of
+		// accumulating; order-service re-inserts the row with USING TTL.
+		ttlReq := map[string]interface{}{
+			"stock_tx_id": order.StockTxID,
+			"stock_id":    order.StockID,
+			"user_id":     order.UserID,
+			"order_type":  order.OrderType,
+			"is_buy":      order.IsBuy,
+			"created":     order.Created,
+		}
+		if err := postJSON(cfg.OrderServiceURL+"/internal/setOrderTTL", ttlReq); err != nil {
+			log.Println("❌ failed to set order TTL in order-service:", err)
+		}
+	}
+	publishOrderStatus(order, status)
+}
+
+// statusEventTypes maps order statuses onto the notification event types
+// users can opt out of in the authentication service. Statuses not listed
+// here (e.g. IN_PROGRESS) are always published.
+var statusEventTypes = map[string]string{
+	"COMPLETED":          "TRADE_COMPLETED",
+	"PARTIALLY_COMPLETE": "PARTIAL_FILL",
+	"EXPIRED":            "ORDER_EXPIRED",
+}
+
+type prefsEntry struct {
+	prefs   map[string]bool
+	fetched time.Time
+}
+
+// prefsCache holds each user's notification preferences for a short window
+// so the hot matching path does not hit the auth service on every fill.
+var prefsCache = struct {
+	sync.Mutex
+	entries map[int]prefsEntry
+}{entries: make(map[int]prefsEntry)}
+
+const prefsCacheTTL = 30 * time.Second
+
+// fetchNotificationPrefs reads a user's preferences from the auth service.
+func fetchNotificationPrefs(userID int) (map[string]bool, error) {
+	url := fmt.Sprintf("%s/internal/notificationPrefs?user_id=%d", cfg.AuthServiceURL, userID)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.InternalSecret != "" {
+		req.Header.Set("X-Internal-Secret", cfg.InternalSecret)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth service returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data map[string]bool `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	return body.Data, nil
+}
+
+// notificationAllowed reports whether the user wants eventType pushed. A
+// preference fetch failure fails open - a flaky auth service must not
+// silence the event stream.
+func notificationAllowed(userID int, eventType string) bool {
+	prefsCache.Lock()
+	entry, ok := prefsCache.entries[userID]
+	prefsCache.Unlock()
+	if !ok || time.Since(entry.fetched) > prefsCacheTTL {
+		prefs, err := fetchNotificationPrefs(userID)
+		if err != nil {
+			log.Println("⚠️ could not fetch notification prefs for user", userID, "-", err)
+			return true
+		}
+		entry = prefsEntry{prefs: prefs, fetched: time.Now()}
+		prefsCache.Lock()
+		prefsCache.entries[userID] = entry
+		prefsCache.Unlock()
+	}
+	enabled, ok := entry.prefs[eventType]
+	return !ok || enabled
+}
+
+// publishOrderStatus announces an order status change over Redis for any
+// interested consumer (SSE streams, monitoring, ...). Statuses that map to
+// an opt-out notification type are dropped for users who disabled them.
+func publishOrderStatus(order *Order, status string) {
+	if eventType, ok := statusEventTypes[status]; ok && !notificationAllowed(order.UserID, eventType) {
+		return
+	}
+	event, err := json.Marshal(map[string]interface{}{
+		"stock_tx_id": order.StockTxID,
+		"stock_id":    order.StockID,
+		"user_id":     order.UserID,
+		"status":      status,
+	})
+	if err != nil {
+		return
+	}
+	if err := redisClient.Publish(context.Background(), "order-status", event).Err(); err != nil {
+		log.Println("❌ failed to publish order status:", err)
+	}
+}
+
+// publishBookUpdate announces a fine-grained book event on the per-stock
+// book-updates channel consumed by the watchStock SSE stream.
+func publishBookUpdate(stockID int, event map[string]interface{}) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	channel := fmt.Sprintf("book-updates:%d", stockID)
+	if err := redisClient.Publish(context.Background(), channel, payload).Err(); err != nil {
+		log.Println("❌ failed to publish book update:", err)
+	}
+}
+
+// ----------------------------------------------------
+// Quotes
+// ----------------------------------------------------
+
+// bestQuote reports the best resting limit prices for a stock. Market orders
+// carry no price, so they are skipped when looking for the touch.
+func bestQuote(stockID int) gin.H {
+	booksMu.RLock()
+	ob, ok := books[stockID]
+	booksMu.RUnlock()
+
+	quote := gin.H{
+		"best_bid":     nil,
+		"best_ask":     nil,
+		"bid_quantity": nil,
+		"ask_quantity": nil,
+		"spread":       nil,
+		"timestamp":    time.Now().Format(time.RFC3339),
+	}
+	if !ok {
+		return quote
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	var bid, ask *Order
+	for _, o := range ob.Buys {
+		if !o.isMarket() {
+			bid = o
+			break
+		}
+	}
+	for _, o := range ob.Sells {
+		if !o.isMarket() {
+			ask = o
+			break
+		}
+	}
+
+	if bid != nil {
+		quote["best_bid"] = bid.Price
+		quote["bid_quantity"] = bid.Quantity
+	}
+	if ask != nil {
+		quote["best_ask"] = ask.Price
+		quote["ask_quantity"] = ask.Quantity
+	}
+	if bid != nil && ask != nil {
+		quote["spread"] = ask.Price - bid.Price
+	}
+	return quote
+}
+
+// DepthLevel is one aggregated price level in a market depth view.
+type DepthLevel struct {
+	Price      float64 `json:"price"`
+	Quantity   int     `json:"quantity"`
+	Cumulative int     `json:"cumulative"`
+}
+
+// aggregateDepth collapses resting orders into at most `levels` price levels,
+// summing quantity per price and accumulating from the best level outward.
+// The input must already be best-first; market orders carry no price and are
+// skipped.
+func aggregateDepth(orders []*Order, levels int) []DepthLevel {
+	out := []DepthLevel{}
+	cumulative := 0
+	for _, o := range orders {
+		if o.isMarket() {
+			continue
+		}
+		if n := len(out); n > 0 && out[n-1].Price == o.Price {
+			out[n-1].Quantity += o.Quantity
+			out[n-1].Cumulative += o.Quantity
+			cumulative += o.Quantity
+			continue
+		}
+		if len(out) == levels {
+			break
+		}
+		cumulative += o.Quantity
+		out = append(out, DepthLevel{Price: o.Price, Quantity: o.Quantity, Cumulative: cumulative})
+	}
+	return out
+}
+
+// marketDepth returns the top price levels on each side of a stock's book.
+func marketDepth(stockID, levels int) gin.H {
+	booksMu.RLock()
+	ob, ok := books[stockID]
+	booksMu.RUnlock()
+
+	depth := gin.H{
+		"stock_id":  stockID,
+		"bids":      []DepthLevel{},
+		"asks":      []DepthLevel{},
+		"timestamp": time.Now().Format(time.RFC3339),
+	}
+	if !ok {
+		return depth
+	}
+
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	depth["bids"] = aggregateDepth(ob.Buys, levels)
+	depth["asks"] = aggregateDepth(ob.Sells, levels)
+	return depth
+}
+
+// orderCounts classifies one stock's resting orders by side and type. The
+// book's mutex must be held by the caller.
+func orderCountsLocked(stockID int, ob *OrderBook) gin.H {
+	var limitBuys, marketBuys, limitSells, marketSells int
+	for _, o := range ob.Buys {
+		if o.isMarket() {
+			marketBuys++
+		} else {
+			limitBuys++
+		}
+	}
+	for _, o := range ob.Sells {
+		if o.isMarket() {
+			marketSells++
+		} else {
+			limitSells++
+		}
+	}
+	return gin.H{
+		"stock_id":          stockID,
+		"buy_count":         len(ob.Buys),
+		"sell_count":        len(ob.Sells),
+		"limit_buy_count":   limitBuys,
+		"market_buy_count":  marketBuys,
+		"limit_sell_count":  limitSells,
+		"market_sell_count": marketSells,
+	}
+}
+
+// stockOrderCountHandler reports the book depth for one stock, split by side
+// and order type, so runaway order flooding is easy to spot.
+func stockOrderCountHandler(c *gin.Context) {
+	stockID, err := strconv.Atoi(c.Query("stock_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid stock_id"})
+		return
+	}
+
+	booksMu.RLock()
+	ob, ok := books[stockID]
+	booksMu.RUnlock()
+	if !ok {
+		ob = &OrderBook{}
+	} else {
+		ob.mu.Lock()
+		defer ob.mu.Unlock()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": orderCountsLocked(stockID, ob)})
+}
+
+// allOrderCountsHandler reports the per-stock book depth for every stock with
+// a book.
+func allOrderCountsHandler(c *gin.Context) {
+	booksMu.RLock()
+	ids := make([]int, 0, len(books))
+	for stockID := range books {
+		ids = append(ids, stockID)
+	}
+	booksMu.RUnlock()
+	sort.Ints(ids)
+
+	counts := make([]gin.H, 0, len(ids))
+	for _, stockID := range ids {
+		ob := getBook(stockID)
+		ob.mu.Lock()
+		counts = append(counts, orderCountsLocked(stockID, ob))
+		ob.mu.Unlock()
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": counts})
+}
+
+// imbalanceHandler reports one book's current imbalance for ad-hoc checks.
+func imbalanceHandler(c *gin.Context) {
+	stockID, err := strconv.Atoi(c.Param("stock_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid stock_id"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"stock_id":  stockID,
+		"imbalance": getBook(stockID).getImbalance(),
+	}})
+}
+
+// publishImbalanceUpdates pushes every active book's imbalance to the
+// imbalance-updates channel every 5 seconds for analytics consumers. A book
+// more one-sided than ±IMBALANCE_ALERT_THRESHOLD is logged at WARN as a
+// potential flash crash indicator.
+func publishImbalanceUpdates(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			booksMu.RLock()
+			obs := make(map[int]*OrderBook, len(books))
+			for stockID, ob := range books {
+				obs[stockID] = ob
+			}
+			booksMu.RUnlock()
+
+			for stockID, ob := range obs {
+				imbalance := ob.getImbalance()
+				if math.Abs(imbalance) > cfg.ImbalanceAlertThreshold {
+					log.Printf("⚠️ WARN: stock %d book imbalance %.2f exceeds ±%.2f",
+						stockID, imbalance, cfg.ImbalanceAlertThreshold)
+				}
+				event, err := json.Marshal(map[string]interface{}{
+					"stock_id":  stockID,
+					"imbalance": imbalance,
+					"timestamp": time.Now().UTC(),
+				})
+				if err != nil {
+					continue
+				}
+				if err := redisClient.Publish(ctx, "imbalance-updates", event).Err(); err != nil {
+					log.Println("❌ failed to publish imbalance update:", err)
+				}
+			}
+		}
+	}
+}
+
+// pendingOrderCountHandler summarizes everything resting in the books for
+// SLA monitoring: total depth, per-stock buy/sell counts, and the age of
+// the oldest resting order. When that age exceeds
+// PENDING_ORDER_AGE_ALERT_SECONDS an alert is logged, so operators see a
+// backed-up pipeline without having to graph the response first.
+func pendingOrderCountHandler(c *gin.Context) {
+	booksMu.RLock()
+	ids := make([]int, 0, len(books))
+	for stockID := range books {
+		ids = append(ids, stockID)
+	}
+	booksMu.RUnlock()
+	sort.Ints(ids)
+
+	total := 0
+	var oldest time.Time
+	byStock := make([]gin.H, 0, len(ids))
+	for _, stockID := range ids {
+		ob := getBook(stockID)
+		ob.mu.Lock()
+		buys, sells := len(ob.Buys), len(ob.Sells)
+		for _, o := range ob.Buys {
+			if oldest.IsZero() || o.Created.Before(oldest) {
+				oldest = o.Created
+			}
+		}
+		for _, o := range ob.Sells {
+			if oldest.IsZero() || o.Created.Before(oldest) {
+				oldest = o.Created
+			}
+		}
+		ob.mu.Unlock()
+
+		if buys == 0 && sells == 0 {
+			continue
+		}
+		total += buys + sells
+		byStock = append(byStock, gin.H{
+			"stock_id":   stockID,
+			"buy_count":  buys,
+			"sell_count": sells,
+		})
+	}
+
+	var ageSeconds float64
+	if !oldest.IsZero() {
+		ageSeconds = time.Since(oldest).Seconds()
+	}
+	if cfg.PendingAgeAlertSeconds > 0 && ageSeconds > float64(cfg.PendingAgeAlertSeconds) {
+		log.Printf("❌ ALERT: oldest pending order has waited %.0fs, threshold is %ds",
+			ageSeconds, cfg.PendingAgeAlertSeconds)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"total_pending":            total,
+		"by_stock":                 byStock,
+		"oldest_order_age_seconds": ageSeconds,
+	}})
+}
+
+func marketDepthHandler(c *gin.Context) {
+	stockID, err := strconv.Atoi(c.Query("stock_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid stock_id"})
+		return
+	}
+	levels := 10
+	if raw := c.Query("levels"); raw != "" {
+		levels, err = strconv.Atoi(raw)
+		if err != nil || levels < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid levels"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": marketDepth(stockID, levels)})
+}
+
+func bestQuoteHandler(c *gin.Context) {
+	raw := c.Param("stock_id")
+	if raw == "" {
+		raw = c.Query("stock_id")
+	}
+	stockID, err := strconv.Atoi(raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid stock_id"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": bestQuote(stockID)})
+}
+
+// ----------------------------------------------------
+// Dead letter queue for failed order events
+// ----------------------------------------------------
+
+// pushToDLQ stores an order event that could not be processed on the
+// order-dlq Redis list, with the failure reason and timestamp, and logs an
+// alert once the queue depth crosses the configured threshold.
+func pushToDLQ(payload string, cause error) {
+	ctx := context.Background()
+	entry, err := json.Marshal(map[string]string{
+		"error":     cause.Error(),
+		"failed_at": time.Now().Format(time.RFC3339),
+		"payload":   payload,
+	})
+	if err != nil {
+		return
+	}
+	depth, err := redisClient.RPush(ctx, "order-dlq", entry).Result()
+	if err != nil {
+		log.Println("❌ failed to push event to DLQ:", err)
+		return
+	}
+	if depth > int64(cfg.DLQAlertThreshold) {
+		log.Printf("ERROR: order-dlq depth %d exceeds alert threshold %d", depth, cfg.DLQAlertThreshold)
+	}
+}
+
+// dlqCountHandler reports how many failed events are waiting in the DLQ.
+func dlqCountHandler(c *gin.Context) {
+	depth, err := redisClient.LLen(c.Request.Context(), "order-dlq").Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Error reading DLQ depth"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{"count": depth}})
+}
+
+// dlqReplayHandler pops up to `count` entries (default 10) off the DLQ and
+// reprocesses their payloads. Events that fail again go back on the queue.
+func dlqReplayHandler(c *gin.Context) {
+	count := 10
+	if v := c.Query("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid count"})
+			return
+		}
+		count = n
+	}
+
+	ctx := c.Request.Context()
+	replayed, requeued := 0, 0
+	for i := 0; i < count; i++ {
+		raw, err := redisClient.LPop(ctx, "order-dlq").Result()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": "Error popping from DLQ"})
+			return
+		}
+		var entry struct {
+			Payload string `json:"payload"`
+		}
+		if err := json.Unmarshal([]byte(raw), &entry); err != nil || entry.Payload == "" {
+			log.Println("❌ dropping malformed DLQ entry:", raw)
+			continue
+		}
+		if err := handleOrderEvent(entry.Payload); err != nil {
+			// handleOrderEvent already re-enqueued it with the new error
+			requeued++
+			continue
+		}
+		replayed++
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"replayed": replayed,
+		"requeued": requeued,
+	}})
+}
+
+// watchStockHandler streams a single stock's book events (TRADE,
+// ORDER_ADDED, ORDER_CANCELLED) over SSE, with a heartbeat every 30 seconds
+// so idle connections are not reaped by intermediaries.
+func watchStockHandler(c *gin.Context) {
+	stockID, err := strconv.Atoi(c.Query("stock_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "Invalid stock_id"})
+		return
+	}
+
+	sub := redisClient.Subscribe(c.Request.Context(), fmt.Sprintf("book-updates:%d", stockID))
+	defer sub.Close()
+	ch := sub.Channel()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+
+	heartbeat := time.NewTicker(30 * time.Second)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case msg, ok := <-ch:
+			if !ok {
+				return false
+			}
+			c.SSEvent("message", msg.Payload)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", time.Now().Format(time.RFC3339))
+			return true
+		}
+	})
+}
+
+// ----------------------------------------------------
+// Event handling
+// ----------------------------------------------------
+
+// Per-stock matching workers. A long matching pass holds the stock's book
+// lock; funneling every stock's events through one goroutine meant a busy
+// stock could stall intake for all the others. Each stock instead gets a
+// dedicated goroutine fed by a bounded channel, so intake for different
+// stocks never contends. addOrder and matchOrders stay directly callable
+// (book warming, tests) - the workers only serialize the event-driven path.
+
+// queuedOrder is one order event in flight to a stock's worker, with the raw
+// payload kept for dead-lettering.
+type queuedOrder struct {
+	order   *Order
+	payload string
+}
+
+var (
+	workersMu sync.Mutex
+	workers   = make(map[int]chan queuedOrder)
+	// workersCtx is the process-lifetime context set by startStockWorkers.
+	// While nil (tests, startup), dispatchOrder processes synchronously.
+	workersCtx context.Context
+)
+
+// workerQueueDepth bounds each stock's intake queue; a full queue dead-
+// letters the event instead of blocking the Redis subscriber.
+const workerQueueDepth = 1024
+
+// startStockWorkers arms the per-stock workers. Workers started afterwards
+// stop when ctx is done.
+func startStockWorkers(ctx context.Context) {
+	workersMu.Lock()
+	workersCtx = ctx
+	workersMu.Unlock()
+}
+
+// runStockWorker drains one stock's queue until ctx is done.
+func runStockWorker(ctx context.Context, stockID int, ch chan queuedOrder) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-ch:
+			if err := processOrder(q.order); err != nil {
+				pushToDLQ(q.payload, err)
+			}
+		}
+	}
+}
+
+// dispatchOrder hands an order event to its stock's worker, starting the
+// goroutine on first use. A full queue dead-letters the event rather than
+// blocking the subscriber behind one overloaded stock.
+func dispatchOrder(order *Order, payload string) error {
+	workersMu.Lock()
+	ctx := workersCtx
+	if ctx == nil {
+		workersMu.Unlock()
+		if err := processOrder(order); err != nil {
+			pushToDLQ(payload, err)
+			return err
+		}
+		return nil
+	}
+	ch, ok := workers[order.StockID]
+	if !ok {
+		ch = make(chan queuedOrder, workerQueueDepth)
+		workers[order.StockID] = ch
+		go runStockWorker(ctx, order.StockID, ch)
+	}
+	workersMu.Unlock()
+
+	select {
+	case ch <- queuedOrder{order: order, payload: payload}:
+		return nil
+	default:
+		err := fmt.Errorf("intake queue for stock %d is full", order.StockID)
+		publishOrderStatus(order, "REJECTED")
+		pushToDLQ(payload, err)
+		atomic.AddInt64(&eventsFailedTotal, 1)
+		return err
+	}
+}
+
+// processOrder appends an order to its book, announces it, and runs the
+// matching pass for its stock. Runs on the stock's worker goroutine (or the
+// caller's, before the workers are armed).
+func processOrder(order *Order) error {
+	if !checkBuyerFunds(order) {
+		// A business rejection, not a pipeline failure: persist the status
+		// and count the event as processed rather than dead-lettering it.
+		finalizeOrder(order, "REJECTED")
+		atomic.AddInt64(&eventsProcessedTotal, 1)
+		lastEventProcessedAt.Store(time.Now())
+		return nil
+	}
+	if err := addOrder(order); err != nil {
+		publishOrderStatus(order, "REJECTED")
+		atomic.AddInt64(&eventsFailedTotal, 1)
+		return err
+	}
+	side := "SELL"
+	if order.IsBuy {
+		side = "BUY"
+	}
+	publishBookUpdate(order.StockID, map[string]interface{}{
+		"event": "ORDER_ADDED", "side": side, "price": order.Price,
+	})
+	matchOrders(order.StockID)
+	atomic.AddInt64(&eventsProcessedTotal, 1)
+	lastEventProcessedAt.Store(time.Now())
+	noteBookEvent()
+	return nil
+}
+
+// handleOrderEvent validates one message from the new-orders channel and
+// routes it to the stock's matching worker.
+func handleOrderEvent(payload string) error {
+	var order Order
+	if err := json.Unmarshal([]byte(payload), &order); err != nil {
+		err = fmt.Errorf("unmarshalling order event: %w", err)
+		pushToDLQ(payload, err)
+		atomic.AddInt64(&eventsFailedTotal, 1)
+		return err
+	}
+	if order.StockTxID == "" || order.Quantity <= 0 {
+		err := fmt.Errorf("invalid order event: %s", payload)
+		pushToDLQ(payload, err)
+		atomic.AddInt64(&eventsFailedTotal, 1)
+		return err
+	}
+	return dispatchOrder(&order, payload)
+}
+
+// cancelItem identifies one order within a BULK_CANCEL event.
+type cancelItem struct {
+	StockID   int    `json:"stock_id"`
+	StockTxID string `json:"stock_tx_id"`
+}
+
+// handleBulkCancel removes a batch of orders from the books. All book
+// pointers are resolved under a single global read lock, then each book is
+// locked once while its orders are removed.
+func handleBulkCancel(items []cancelItem) {
+	byBook := make(map[*OrderBook][]cancelItem)
+	booksMu.RLock()
+	for _, item := range items {
+		if ob, ok := books[item.StockID]; ok {
+			byBook[ob] = append(byBook[ob], item)
+		}
+	}
+	booksMu.RUnlock()
+
+	removed := 0
+	for ob, bookItems := range byBook {
+		ob.mu.Lock()
+		for _, item := range bookItems {
+			if ob.removeLocked(item.StockTxID) {
+				removed++
+				publishBookUpdate(item.StockID, map[string]interface{}{
+					"event": "ORDER_CANCELLED", "stock_tx_id": item.StockTxID,
+				})
+			}
+		}
+		ob.mu.Unlock()
+	}
+	log.Printf("✅ Bulk cancel removed %d of %d orders from the books", removed, len(items))
+}
+
+// handleCancellationEvent drops resting orders from the book - either a
+// single cancellation (e.g. an expired market maker quote) or a BULK_CANCEL
+// event carrying many order IDs at once.
+func handleCancellationEvent(payload string) error {
+	var event struct {
+		EventType string       `json:"event_type"`
+		StockID   int          `json:"stock_id"`
+		StockTxID string       `json:"stock_tx_id"`
+		Orders    []cancelItem `json:"orders"`
+	}
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return fmt.Errorf("unmarshalling cancellation event: %w", err)
+	}
+	if event.EventType == "BULK_CANCEL" {
+		if len(event.Orders) == 0 {
+			return fmt.Errorf("empty bulk cancel event: %s", payload)
+		}
+		handleBulkCancel(event.Orders)
+		return nil
+	}
+	if event.StockTxID == "" {
+		return fmt.Errorf("invalid cancellation event: %s", payload)
+	}
+	if !removeOrder(event.StockID, event.StockTxID) {
+		log.Printf("Warning: cancellation for unknown order %s (stock %d)", event.StockTxID, event.StockID)
+		return nil
+	}
+	publishBookUpdate(event.StockID, map[string]interface{}{
+		"event": "ORDER_CANCELLED", "stock_tx_id": event.StockTxID,
+	})
+	return nil
+}
+
+// subscribeCancellations consumes the Redis order-cancellations channel until
+// ctx is done.
+func subscribeCancellations(ctx context.Context) {
+	sub := redisClient.Subscribe(ctx, "order-cancellations")
+	defer sub.Close()
+	ch := sub.Channel()
+	log.Println("✅ Subscribed to order-cancellations channel")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			if err := handleCancellationEvent(msg.Payload); err != nil {
+				log.Println("❌ failed to handle cancellation event:", err)
+			} else {
+				noteBookEvent()
+			}
+		}
+	}
+}
+
+// warmOrderBook pulls every IN_PROGRESS order from order-service and loads
+// the ones not already resting in the book. Returns orders loaded per stock.
+func warmOrderBook() (map[int]int, error) {
+	resp, err := http.Get(cfg.OrderServiceURL + "/internal/getAllActiveOrders")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("order service returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Success bool    `json:"success"`
+		Data    []Order `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, err
+	}
+	if !body.Success {
+		return nil, fmt.Errorf("order service reported failure")
+	}
+
+	// Orders already in the book (e.g. restored from a snapshot, or a
+	// repeated warm call) must not be duplicated.
+	resting := make(map[string]bool)
+	booksMu.RLock()
+	for _, ob := range books {
+		ob.mu.Lock()
+		for _, o := range ob.Buys {
+			resting[o.StockTxID] = true
+		}
+		for _, o := range ob.Sells {
+			resting[o.StockTxID] = true
+		}
+		ob.mu.Unlock()
+	}
+	booksMu.RUnlock()
+
+	loaded := make(map[int]int)
+	for i := range body.Data {
+		order := body.Data[i]
+		if resting[order.StockTxID] {
+			continue
+		}
+		if err := addOrder(&order); err != nil {
+			log.Printf("⚠️ could not warm order %s: %v", order.StockTxID, err)
+			continue
+		}
+		loaded[order.StockID]++
+	}
+	for stockID, n := range loaded {
+		log.Printf("✅ Warmed order book for stock %d with %d orders", stockID, n)
+	}
+	return loaded, nil
+}
+
+// warmOrderBookHandler exposes book warming for the order-service dependency
+// chain; repeated calls are safe because resting orders are skipped.
+func warmOrderBookHandler(c *gin.Context) {
+	loaded, err := warmOrderBook()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"success": false, "message": "Failed to warm order book: " + err.Error()})
+		return
+	}
+	total := 0
+	for _, n := range loaded {
+		total += n
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": gin.H{
+		"orders_loaded": total,
+		"by_stock":      loaded,
+	}})
+}
+
+// subscribeNewOrders consumes the Redis new-orders channel until ctx is done.
+func subscribeNewOrders(ctx context.Context) {
+	sub := redisClient.Subscribe(ctx, "new-orders")
+	defer sub.Close()
+	ch := sub.Channel()
+	log.Println("✅ Subscribed to new-orders channel")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			// Snapshot accounting (noteBookEvent) happens in processOrder,
+			// on whichever goroutine actually mutates the book.
+			if err := handleOrderEvent(msg.Payload); err != nil {
+				log.Println("❌ failed to handle order event:", err)
+			}
+		}
+	}
+}
+
+// ----------------------------------------------------
+// Engine liveness status
+// ----------------------------------------------------
+
+// Liveness state for matchingServiceStatus. lastEventProcessedAt holds a
+// time.Time stored after every successfully handled order event.
+var (
+	serviceStartedAt     = time.Now()
+	lastEventProcessedAt atomic.Value
+	eventsProcessedTotal int64
+	eventsFailedTotal    int64
+)
+
+// matchingServiceStatusHandler answers GET /internal/matchingServiceStatus
+// with enough to tell a processing engine from a stuck one. When
+// HEALTH_LAG_THRESHOLD is set and the last processed event is older than
+// that many seconds, the response is a 503 so orchestrators can restart the
+// service.
+func matchingServiceStatusHandler(c *gin.Context) {
+	booksMu.RLock()
+	booksCount := len(books)
+	booksMu.RUnlock()
+
+	var lastProcessed time.Time
+	if v := lastEventProcessedAt.Load(); v != nil {
+		lastProcessed = v.(time.Time)
+	}
+
+	status := gin.H{
+		"events_processed_total": atomic.LoadInt64(&eventsProcessedTotal),
+		"events_failed_total":    atomic.LoadInt64(&eventsFailedTotal),
+		"books_count":            booksCount,
+		"redis_connected":        redisClient.Ping(c.Request.Context()).Err() == nil,
+		"uptime_seconds":         int64(time.Since(serviceStartedAt).Seconds()),
+	}
+	if lastProcessed.IsZero() {
+		status["last_event_processed_at"] = nil
+	} else {
+		status["last_event_processed_at"] = lastProcessed.Format(time.RFC3339Nano)
+	}
+
+	code := http.StatusOK
+	if cfg.HealthLagThreshold > 0 && !lastProcessed.IsZero() &&
+		time.Since(lastProcessed) > time.Duration(cfg.HealthLagThreshold)*time.Second {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"success": code == http.StatusOK, "data": status})
+}
+
+// ----------------------------------------------------
+// Panic recovery
+// ----------------------------------------------------
+
+// panicRecovery keeps a panicking handler from taking down the whole matching
+// engine: the panic is logged with its stack, published as a PANIC alert on
+// the shared Redis "service-alerts" channel, and answered with a 500.
+func panicRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		stack := string(debug.Stack())
+		log.Printf("❌ PANIC in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, recovered, stack)
+
+		lines := strings.Split(stack, "\n")
+		if len(lines) > 20 {
+			lines = lines[:20]
+		}
+		alert, _ := json.Marshal(gin.H{
+			"type":         "PANIC",
+			"service_name": "matching-service",
+			"panic_value":  fmt.Sprintf("%v", recovered),
+			"stack":        strings.Join(lines, "\n"),
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		if err := redisClient.Publish(context.Background(), "service-alerts", alert).Err(); err != nil {
+			log.Println("⚠️ could not publish panic alert:", err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// ----------------------------------------------------
+// main
+// ----------------------------------------------------
+func main() {
+	if sandboxMode() {
+		gin.SetMode(gin.TestMode)
+	}
+	loadConfig()
+	initRedis()
+
+	// Durable book recovery: restore the last snapshots before the
+	// subscribers start mutating the books.
+	if cfg.OrderBookBackend == "postgres" {
+		if err := initSnapshotDB(); err != nil {
+			log.Fatalf("❌ order book snapshot backend unavailable: %v", err)
+		}
+		if err := loadBookSnapshots(); err != nil {
+			log.Fatalf("❌ failed to restore order book snapshots: %v", err)
+		}
+	}
+
+	// Rebuild the book from Cassandra's IN_PROGRESS orders before taking
+	// traffic; order-service being down only delays warming, it can re-run
+	// it later through /internal/warmOrderBook.
+	if _, err := warmOrderBook(); err != nil {
+		log.Println("⚠️ could not warm order book from order-service:", err)
+	}
+
+	loadReferencePrices()
+	checkOrderHistoryHealth()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startStockWorkers(ctx)
+	go subscribeNewOrders(ctx)
+	go subscribeCancellations(ctx)
+	go monitorOrderHistory(ctx)
+	go logOrderBookStats(ctx)
+	go publishImbalanceUpdates(ctx)
+
+	r := gin.New()
+	r.Use(gin.Logger(), panicRecovery())
+
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+	})
+	r.GET("/health/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	r.GET("/health/ready", func(c *gin.Context) {
+		if err := redisClient.Ping(c).Err(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "redis": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
+	// Best bid/ask per stock. The /engine path matches what the api-gateway
+	// forwards; the short path is for direct internal use.
+	r.GET("/bestQuote/:stock_id", bestQuoteHandler)
+	r.GET("/engine/getBestQuote", bestQuoteHandler)
+	r.GET("/engine/getMarketDepth", marketDepthHandler)
+	r.GET("/engine/getStockOrderCount", stockOrderCountHandler)
+	r.GET("/engine/getAllOrderCounts", allOrderCountsHandler)
+	r.GET("/engine/getPendingOrderCount", pendingOrderCountHandler)
+
+	// Live per-stock book event stream
+	r.GET("/engine/watchStock", watchStockHandler)
+
+	// Book warming after a restart
+	r.POST("/internal/warmOrderBook", warmOrderBookHandler)
+
+	// Current book imbalance, also streamed on the imbalance-updates channel
+	r.GET("/internal/imbalance/:stock_id", imbalanceHandler)
+
+	// Engine liveness for operators: is the event loop still processing?
+	r.GET("/internal/matchingServiceStatus", matchingServiceStatusHandler)
+
+	// Dead letter queue for order events that could not be processed
+	r.GET("/internal/dlq/count", dlqCountHandler)
+	r.POST("/internal/dlq/replay", dlqReplayHandler)
+
+	// Admin replay of a failed trade compensation recorded in order-history.
+	r.POST("/internal/admin/retryFailedTrade", func(c *gin.Context) {
+		var req struct {
+			StockTxID  string `json:"stock_tx_id"`
+			FailedStep string `json:"failed_step"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.StockTxID == "" || req.FailedStep == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "message": "stock_tx_id and failed_step are required"})
+			return
+		}
+		if err := retryFailedTrade(req.StockTxID, req.FailedStep); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": nil})
+	})
+
+	log.Printf("Matching service starting on port %s", cfg.Port)
+	if err := r.Run(":" + cfg.Port); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+// Shared test fixtures plus end-to-end tests for the order event pipeline:
+// crafted JSON payloads are fed through handleOrderEvent exactly as the
+// pub/sub subscriber would deliver them, and the stubbed wallet and
+// order-history servers record which settlement calls the matcher made.
+//
+// TestMain owns the fixtures for the whole package. With
+// RUN_INTEGRATION_TESTS=true (and a Docker daemon) a real Redis container is
+// started via testcontainers-go and initRedis points at it, so publishes go
+// over the wire; otherwise publishes fail open against an unreachable
+// address, which nothing under test depends on.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// callCounter tracks how many times each stubbed downstream path was hit.
+type callCounter struct {
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func (cc *callCounter) add(path string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.hits[path]++
+}
+
+func (cc *callCounter) count(path string) int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.hits[path]
+}
+
+func (cc *callCounter) reset() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.hits = make(map[string]int)
+}
+
+var downstreamCalls = &callCounter{hits: make(map[string]int)}
+
+func TestMain(m *testing.M) {
+	// Stub every downstream service with a happy-path responder that counts
+	// the paths it serves.
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		downstreamCalls.add(req.URL.Path)
+		if req.URL.Path == "/internal/updateOrderStatus" {
+			var update struct {
+				StockTxID   string `json:"stock_tx_id"`
+				OrderStatus string `json:"order_status"`
+			}
+			_ = json.NewDecoder(req.Body).Decode(&update)
+			recorder.add(update.StockTxID, update.OrderStatus)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.URL.Path == "/internal/getWalletBalance" {
+			// The pre-trade funds check reads this; keep every test buyer
+			// rich enough that only tests stubbing it differently reject.
+			_, _ = w.Write([]byte(`{"success":true,"data":{"balance":1000000}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"success":true,"data":null}`))
+	}))
+	defer stub.Close()
+
+	loadConfig()
+	cfg.WalletURL = stub.URL
+	cfg.OrderHistoryURL = stub.URL
+	cfg.OrderServiceURL = stub.URL
+	cfg.TradeFeePct = 0 // keep settlement deterministic for assertions
+
+	if os.Getenv("RUN_INTEGRATION_TESTS") == "true" {
+		ctx := context.Background()
+		container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+			ContainerRequest: testcontainers.ContainerRequest{
+				Image:        "redis:7-alpine",
+				ExposedPorts: []string{"6379/tcp"},
+				WaitingFor:   wait.ForListeningPort("6379/tcp").WithStartupTimeout(time.Minute),
+			},
+			Started: true,
+		})
+		if err != nil {
+			log.Fatal("failed to start Redis container:", err)
+		}
+		host, err := container.Host(ctx)
+		if err != nil {
+			log.Fatal("failed to read container host:", err)
+		}
+		port, err := container.MappedPort(ctx, "6379")
+		if err != nil {
+			log.Fatal("failed to read container port:", err)
+		}
+		cfg.RedisAddr = fmt.Sprintf("%s:%s", host, port.Port())
+		initRedis()
+
+		code := m.Run()
+		if err := container.Terminate(ctx); err != nil {
+			log.Println("failed to terminate Redis container:", err)
+		}
+		os.Exit(code)
+	}
+
+	// Publishes fail open against an unreachable Redis; the matching logic
+	// under test never depends on them succeeding.
+	redisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})
+
+	os.Exit(m.Run())
+}
+
+// orderEventJSON serializes an order the way order-service publishes it on
+// the new-orders channel.
+func orderEventJSON(t *testing.T, o *Order) string {
+	t.Helper()
+	b, err := json.Marshal(o)
+	if err != nil {
+		t.Fatalf("marshalling order event: %v", err)
+	}
+	return string(b)
+}
+
+func TestHandleOrderEventAddsToBook(t *testing.T) {
+	resetBooks()
+	downstreamCalls.reset()
+	const stockID = 40
+
+	payload := orderEventJSON(t, limitOrder("evt-rest", stockID, 100, true, 10, 25))
+	if err := handleOrderEvent(payload); err != nil {
+		t.Fatalf("handleOrderEvent: %v", err)
+	}
+
+	buys, sells := bookSizes(stockID)
+	if buys != 1 || sells != 0 {
+		t.Fatalf("book after non-crossing event: %d buys, %d sells, want 1/0", buys, sells)
+	}
+
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	resting := ob.Buys[0].StockTxID
+	ob.mu.Unlock()
+	if resting != "evt-rest" {
+		t.Fatalf("resting order is %q, want evt-rest", resting)
+	}
+}
+
+func TestHandleOrderEventRejectsBadPayloads(t *testing.T) {
+	resetBooks()
+	downstreamCalls.reset()
+
+	if err := handleOrderEvent("{not json"); err == nil {
+		t.Fatal("malformed JSON accepted, want error")
+	}
+	if err := handleOrderEvent(`{"stock_tx_id":"","quantity":5}`); err == nil {
+		t.Fatal("event without stock_tx_id accepted, want error")
+	}
+	if err := handleOrderEvent(`{"stock_tx_id":"evt-bad","quantity":0}`); err == nil {
+		t.Fatal("event with zero quantity accepted, want error")
+	}
+}
+
+func TestHandleOrderEventCrossSettlesAndRecords(t *testing.T) {
+	resetBooks()
+	downstreamCalls.reset()
+	const stockID = 41
+
+	rest := orderEventJSON(t, limitOrder("evt-sell", stockID, 200, false, 10, 25))
+	if err := handleOrderEvent(rest); err != nil {
+		t.Fatalf("handleOrderEvent(resting sell): %v", err)
+	}
+	cross := orderEventJSON(t, limitOrder("evt-buy", stockID, 100, true, 10, 25))
+	if err := handleOrderEvent(cross); err != nil {
+		t.Fatalf("handleOrderEvent(crossing buy): %v", err)
+	}
+
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("book not empty after full cross: %d buys, %d sells", buys, sells)
+	}
+	if got := recorder.last("evt-buy"); got != "COMPLETED" {
+		t.Fatalf("buy order finished as %q, want COMPLETED", got)
+	}
+	if got := recorder.last("evt-sell"); got != "COMPLETED" {
+		t.Fatalf("sell order finished as %q, want COMPLETED", got)
+	}
+
+	// One fill: buyer debited once, seller credited once, both portfolios
+	// touched, and one trade recorded in order-history.
+	if got := downstreamCalls.count("/internal/deductMoneyFromWallet"); got != 1 {
+		t.Fatalf("deductMoneyFromWallet called %d times, want 1", got)
+	}
+	if got := downstreamCalls.count("/internal/addMoneyToWallet"); got != 1 {
+		t.Fatalf("addMoneyToWallet called %d times, want 1", got)
+	}
+	if got := downstreamCalls.count("/internal/updateStockPortfolio"); got != 2 {
+		t.Fatalf("updateStockPortfolio called %d times, want 2", got)
+	}
+	if got := downstreamCalls.count("/internal/recordStockTransaction"); got != 1 {
+		t.Fatalf("recordStockTransaction called %d times, want 1", got)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+// Unit tests for the matching loop. The shared fixtures - stubbed downstream
+// services and the Redis client - are set up once in TestMain (main_test.go),
+// so these run with no infrastructure and are race-detector clean.
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// statusRecorder collects the order status updates pushed to the stubbed
+// order-service endpoint.
+type statusRecorder struct {
+	mu       sync.Mutex
+	statuses map[string][]string // stock_tx_id -> statuses in arrival order
+}
+
+func (r *statusRecorder) add(stockTxID, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses[stockTxID] = append(r.statuses[stockTxID], status)
+}
+
+func (r *statusRecorder) last(stockTxID string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.statuses[stockTxID]
+	if len(s) == 0 {
+		return ""
+	}
+	return s[len(s)-1]
+}
+
+func (r *statusRecorder) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statuses = make(map[string][]string)
+}
+
+var recorder = &statusRecorder{statuses: make(map[string][]string)}
+
+// resetBooks clears all order book state between tests.
+func resetBooks() {
+	booksMu.Lock()
+	books = make(map[int]*OrderBook)
+	booksMu.Unlock()
+	atomic.StoreInt64(&totalBookEntries, 0)
+	lastTradeMu.Lock()
+	lastTradePrice = make(map[int]float64)
+	lastTradeMu.Unlock()
+	recorder.reset()
+}
+
+func limitOrder(txID string, stockID, userID int, isBuy bool, qty int, price float64) *Order {
+	return &Order{
+		StockID:   stockID,
+		StockTxID: txID,
+		UserID:    userID,
+		OrderType: "LIMIT",
+		IsBuy:     isBuy,
+		Quantity:  qty,
+		Price:     price,
+		Created:   time.Now(),
+	}
+}
+
+func marketOrder(txID string, stockID, userID int, isBuy bool, qty int) *Order {
+	o := limitOrder(txID, stockID, userID, isBuy, qty, 0)
+	o.OrderType = "MARKET"
+	return o
+}
+
+func mustAdd(t *testing.T, o *Order) {
+	t.Helper()
+	if err := addOrder(o); err != nil {
+		t.Fatalf("addOrder(%s): %v", o.StockTxID, err)
+	}
+}
+
+// bookSizes returns the current buy/sell depth for a stock.
+func bookSizes(stockID int) (buys, sells int) {
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	return len(ob.Buys), len(ob.Sells)
+}
+
+func TestCrossingLimitOrdersComplete(t *testing.T) {
+	resetBooks()
+	const stockID = 1
+
+	mustAdd(t, limitOrder("buy-1", stockID, 100, true, 10, 25))
+	mustAdd(t, limitOrder("sell-1", stockID, 200, false, 10, 25))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("book not empty after full cross: %d buys, %d sells", buys, sells)
+	}
+	if got := recorder.last("buy-1"); got != "COMPLETED" {
+		t.Fatalf("buy order finished as %q, want COMPLETED", got)
+	}
+	if got := recorder.last("sell-1"); got != "COMPLETED" {
+		t.Fatalf("sell order finished as %q, want COMPLETED", got)
+	}
+}
+
+func TestNonCrossingOrderRests(t *testing.T) {
+	resetBooks()
+	const stockID = 2
+
+	mustAdd(t, limitOrder("buy-hi", stockID, 100, true, 10, 25))
+	mustAdd(t, limitOrder("buy-lo", stockID, 101, true, 10, 20))
+	mustAdd(t, limitOrder("sell-1", stockID, 200, false, 10, 25))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 1 || sells != 0 {
+		t.Fatalf("book after partial cross: %d buys, %d sells, want 1/0", buys, sells)
+	}
+
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	resting := ob.Buys[0].StockTxID
+	ob.mu.Unlock()
+	if resting != "buy-lo" {
+		t.Fatalf("resting order is %q, want buy-lo", resting)
+	}
+	if got := recorder.last("buy-lo"); got != "" {
+		t.Fatalf("resting order got status update %q, want none", got)
+	}
+}
+
+func TestMarketBuyMatchesAnySellPrice(t *testing.T) {
+	resetBooks()
+	const stockID = 3
+
+	mustAdd(t, limitOrder("sell-exp", stockID, 200, false, 5, 999.99))
+	mustAdd(t, marketOrder("mkt-buy", stockID, 100, true, 5))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("market buy did not clear the book: %d buys, %d sells", buys, sells)
+	}
+	if got := recorder.last("mkt-buy"); got != "COMPLETED" {
+		t.Fatalf("market buy finished as %q, want COMPLETED", got)
+	}
+}
+
+func TestMarketSellMatchesAnyBuyPrice(t *testing.T) {
+	resetBooks()
+	const stockID = 4
+
+	mustAdd(t, limitOrder("buy-low", stockID, 100, true, 5, 0.01))
+	mustAdd(t, marketOrder("mkt-sell", stockID, 200, false, 5))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("market sell did not clear the book: %d buys, %d sells", buys, sells)
+	}
+	if got := recorder.last("mkt-sell"); got != "COMPLETED" {
+		t.Fatalf("market sell finished as %q, want COMPLETED", got)
+	}
+}
+
+func TestPartialFillLeavesRemainder(t *testing.T) {
+	resetBooks()
+	const stockID = 5
+
+	mustAdd(t, limitOrder("buy-big", stockID, 100, true, 10, 30))
+	mustAdd(t, limitOrder("sell-small", stockID, 200, false, 4, 30))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 1 || sells != 0 {
+		t.Fatalf("book after partial fill: %d buys, %d sells, want 1/0", buys, sells)
+	}
+
+	ob := getBook(stockID)
+	ob.mu.Lock()
+	remaining := ob.Buys[0].Quantity
+	ob.mu.Unlock()
+	if remaining != 6 {
+		t.Fatalf("buy remainder = %d, want 6", remaining)
+	}
+	if got := recorder.last("buy-big"); got != "PARTIALLY_COMPLETE" {
+		t.Fatalf("partially filled buy has status %q, want PARTIALLY_COMPLETE", got)
+	}
+	if got := recorder.last("sell-small"); got != "COMPLETED" {
+		t.Fatalf("fully filled sell has status %q, want COMPLETED", got)
+	}
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	resetBooks()
+	const (
+		stockID = 6
+		userID  = 100
+	)
+
+	buy := limitOrder("self-buy", stockID, userID, true, 10, 25)
+	sell := limitOrder("self-sell", stockID, userID, false, 10, 25)
+	sell.Created = buy.Created.Add(time.Second) // the sell is the newer side
+	mustAdd(t, buy)
+	mustAdd(t, sell)
+	matchOrders(stockID)
+
+	// Cancel-newest: no fill between the two, the newer side is cancelled
+	// out of the book, and the older side keeps its queue position.
+	buys, sells := bookSizes(stockID)
+	if buys != 1 || sells != 0 {
+		t.Fatalf("book after self-cross: %d buys, %d sells, want 1/0", buys, sells)
+	}
+	if got := recorder.last("self-buy"); got != "" {
+		t.Fatalf("self-trade produced status update %q for the older buy", got)
+	}
+	if got := recorder.last("self-sell"); got != "CANCELLED" {
+		t.Fatalf("newer self-crossing sell has status %q, want CANCELLED", got)
+	}
+}
+
+// A self-crossing pair must not wedge the stock: after the newer side is
+// cancelled, other users' orders still match against the survivor.
+func TestSelfTradePreventionKeepsBookLive(t *testing.T) {
+	resetBooks()
+	const (
+		stockID = 16
+		userID  = 100
+	)
+
+	buy := limitOrder("wedge-buy", stockID, userID, true, 10, 25)
+	sell := limitOrder("wedge-sell", stockID, userID, false, 10, 0.01)
+	sell.Created = buy.Created.Add(time.Second)
+	mustAdd(t, buy)
+	mustAdd(t, sell)
+	mustAdd(t, limitOrder("other-sell", stockID, 200, false, 10, 25))
+	matchOrders(stockID)
+
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("book not drained after self-pair cancelled: %d buys, %d sells", buys, sells)
+	}
+	if got := recorder.last("wedge-buy"); got != "COMPLETED" {
+		t.Fatalf("surviving buy finished as %q, want COMPLETED", got)
+	}
+	if got := recorder.last("other-sell"); got != "COMPLETED" {
+		t.Fatalf("third-party sell finished as %q, want COMPLETED", got)
+	}
+}
+
+func TestConcurrentAddAndMatch(t *testing.T) {
+	resetBooks()
+	const (
+		stockID = 7
+		pairs   = 50
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < pairs; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buy := limitOrder("c-buy", stockID, 100+i, true, 1, 10)
+			sell := limitOrder("c-sell", stockID, 1000+i, false, 1, 10)
+			_ = addOrder(buy)
+			_ = addOrder(sell)
+			matchOrders(stockID)
+		}(i)
+	}
+	wg.Wait()
+	matchOrders(stockID)
+
+	// Every buy crosses some sell at the same price, so the book must drain
+	// completely and the global entry counter must agree with the book.
+	buys, sells := bookSizes(stockID)
+	if buys != 0 || sells != 0 {
+		t.Fatalf("book not drained after concurrent matching: %d buys, %d sells", buys, sells)
+	}
+	if n := atomic.LoadInt64(&totalBookEntries); n != 0 {
+		t.Fatalf("totalBookEntries = %d after book drained, want 0", n)
+	}
+}
+
+func TestParseWalletBalance(t *testing.T) {
+	cases := []struct {
+		name    string
+		body    string
+		want    float64
+		wantErr bool
+	}{
+		{"valid", `{"success":true,"data":{"balance":1234.56}}`, 1234.56, false},
+		{"zero balance", `{"success":true,"data":{"balance":0}}`, 0, false},
+		{"missing data", `{"success":true}`, 0, true},
+		{"failure response", `{"success":false,"data":null}`, 0, true},
+		{"data wrong shape", `{"success":true,"data":[1,2]}`, 0, true},
+		{"not json", `<html>`, 0, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseWalletBalance([]byte(tc.body))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseWalletBalance(%q) = %v, want error", tc.body, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseWalletBalance(%q) returned error: %v", tc.body, err)
+			}
+			if got != tc.want {
+				t.Fatalf("parseWalletBalance(%q) = %v, want %v", tc.body, got, tc.want)
+			}
+		})
+	}
+}
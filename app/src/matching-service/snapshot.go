@@ -0,0 +1,164 @@
+package main
+
+// Durable order book snapshots. When ORDER_BOOK_BACKEND=postgres the service
+// connects to CockroachDB at startup, restores the latest snapshot per stock
+// into the in-memory books, and rewrites the snapshots after every
+// SNAPSHOT_EVERY_EVENTS processed book events. This gives the book a recovery
+// path that does not depend on Redis being reachable during a restart.
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+var (
+	snapshotDB     *sql.DB
+	snapshotEvents int64 // processed book events since startup
+)
+
+// bookSnapshot is the JSONB payload stored per stock.
+type bookSnapshot struct {
+	Buys  []*Order `json:"buys"`
+	Sells []*Order `json:"sells"`
+}
+
+// snapshotsEnabled reports whether the postgres snapshot backend is active.
+func snapshotsEnabled() bool {
+	return snapshotDB != nil
+}
+
+// initSnapshotDB connects to CockroachDB and ensures the snapshot table
+// exists. Called only when ORDER_BOOK_BACKEND=postgres.
+func initSnapshotDB() error {
+	host := os.Getenv("COCKROACH_DB_HOST")
+	if host == "" {
+		host = "cockroach-db:26257"
+	}
+
+	dbName := "matching-db"
+	if sandboxMode() {
+		dbName = "sandbox-matching-db"
+	}
+
+	dsn := fmt.Sprintf("postgresql://root@%s/?sslmode=disable", host)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("error opening DB: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS "%s";`, dbName)); err != nil {
+		return fmt.Errorf("error creating '%s': %v", dbName, err)
+	}
+
+	dsn = fmt.Sprintf("postgresql://root@%s/%s?sslmode=disable", host, dbName)
+	snapshotDB, err = sql.Open("postgres", dsn)
+	if err != nil {
+		return fmt.Errorf("error connecting to '%s': %v", dbName, err)
+	}
+	if err = snapshotDB.Ping(); err != nil {
+		snapshotDB.Close()
+		snapshotDB = nil
+		return fmt.Errorf("error pinging '%s': %v", dbName, err)
+	}
+
+	_, err = snapshotDB.Exec(`
+		CREATE TABLE IF NOT EXISTS matching_snapshots (
+			stock_id INT PRIMARY KEY,
+			snapshot JSONB NOT NULL,
+			snapshotted_at TIMESTAMP NOT NULL DEFAULT current_timestamp
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating matching_snapshots table: %v", err)
+	}
+	return nil
+}
+
+// loadBookSnapshots restores the latest snapshot of every stock's book into
+// the in-memory books map. Meant to run before the subscribers start.
+func loadBookSnapshots() error {
+	rows, err := snapshotDB.Query(`SELECT stock_id, snapshot FROM matching_snapshots`)
+	if err != nil {
+		return fmt.Errorf("error querying snapshots: %v", err)
+	}
+	defer rows.Close()
+
+	restored := 0
+	var entries int64
+	booksMu.Lock()
+	defer booksMu.Unlock()
+	for rows.Next() {
+		var stockID int
+		var raw []byte
+		if err := rows.Scan(&stockID, &raw); err != nil {
+			return fmt.Errorf("error scanning snapshot: %v", err)
+		}
+		var snap bookSnapshot
+		if err := json.Unmarshal(raw, &snap); err != nil {
+			log.Printf("⚠️ skipping corrupt snapshot for stock %d: %v", stockID, err)
+			continue
+		}
+		books[stockID] = &OrderBook{Buys: snap.Buys, Sells: snap.Sells}
+		entries += int64(len(snap.Buys) + len(snap.Sells))
+		restored++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating snapshots: %v", err)
+	}
+	atomic.StoreInt64(&totalBookEntries, entries)
+
+	if restored > 0 {
+		log.Printf("✅ Restored order book snapshots for %d stocks (%d entries)", restored, entries)
+	}
+	return nil
+}
+
+// writeBookSnapshots upserts the current book of every stock. Runs in its own
+// goroutine; failures are logged and retried at the next snapshot interval.
+func writeBookSnapshots() {
+	booksMu.RLock()
+	stockIDs := make([]int, 0, len(books))
+	for stockID := range books {
+		stockIDs = append(stockIDs, stockID)
+	}
+	booksMu.RUnlock()
+
+	now := time.Now()
+	for _, stockID := range stockIDs {
+		ob := getBook(stockID)
+		ob.mu.Lock()
+		raw, err := json.Marshal(bookSnapshot{Buys: ob.Buys, Sells: ob.Sells})
+		ob.mu.Unlock()
+		if err != nil {
+			log.Printf("❌ failed to marshal snapshot for stock %d: %v", stockID, err)
+			continue
+		}
+
+		_, err = snapshotDB.Exec(`
+			UPSERT INTO matching_snapshots (stock_id, snapshot, snapshotted_at)
+			VALUES ($1, $2, $3)
+		`, stockID, raw, now)
+		if err != nil {
+			log.Printf("❌ failed to write snapshot for stock %d: %v", stockID, err)
+		}
+	}
+}
+
+// noteBookEvent counts one processed book event and triggers a snapshot
+// rewrite every cfg.SnapshotEveryEvents events.
+func noteBookEvent() {
+	if !snapshotsEnabled() {
+		return
+	}
+	if n := atomic.AddInt64(&snapshotEvents, 1); n%int64(cfg.SnapshotEveryEvents) == 0 {
+		go writeBookSnapshots()
+	}
+}
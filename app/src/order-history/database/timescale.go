@@ -39,6 +39,10 @@ func NewTimescaleDBHandler() (*TimescaleDBHandler, error) {
 	if dbname == "" {
 		dbname = "order_history"
 	}
+	// Keep sandbox runs away from the production history data.
+	if os.Getenv("SANDBOX_MODE") == "true" {
+		dbname = "sandbox_" + dbname
+	}
 
 	connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
 		user, password, host, port, dbname)
@@ -79,19 +83,27 @@ func (h *TimescaleDBHandler) Close() {
 }
 
 func (h *TimescaleDBHandler) RunMigrations() error {
-	// Read the migration file
-	migrationSQL, err := os.ReadFile("migrations/001_create_tables.sql")
-	if err != nil {
-		return fmt.Errorf("failed to read migration file: %v", err)
+	migrationFiles := []string{
+		"migrations/001_create_tables.sql",
+		"migrations/002_market_data.sql",
+		"migrations/003_failed_trades.sql",
+		"migrations/004_trade_tape.sql",
+		"migrations/005_parent_tx_index.sql",
 	}
 
-	// Execute the migration script
+	// Create context with timeout for the migration scripts
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
-	_, err = h.pool.Exec(ctx, string(migrationSQL))
-	if err != nil {
-		return fmt.Errorf("failed to execute migrations: %v", err)
+	for _, file := range migrationFiles {
+		migrationSQL, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read migration file %s: %v", file, err)
+		}
+
+		if _, err := h.pool.Exec(ctx, string(migrationSQL)); err != nil {
+			return fmt.Errorf("failed to execute migration %s: %v", file, err)
+		}
 	}
 
 	log.Println("Successfully applied migrations")
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"time"
 
 	"main/database"
@@ -13,6 +16,7 @@ import (
 	"main/service"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
 )
 
@@ -21,6 +25,13 @@ func main() {
 		log.Println("No .env file found, using environment variables")
 	}
 
+	// Sandbox runs use a sandbox_-prefixed database (see database package)
+	// and gin's test mode, so CI suites cannot touch production data.
+	sandbox := os.Getenv("SANDBOX_MODE") == "true"
+	if sandbox {
+		gin.SetMode(gin.TestMode)
+	}
+
 	dbHandler, err := database.NewTimescaleDBHandler()
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
@@ -35,8 +46,21 @@ func main() {
 	// Initialize services
 	txService := service.NewTransactionService(dbHandler)
 
+	// Redis is only used for the service-alerts channel here; sandbox runs
+	// share the platform-wide convention of Redis DB 1.
+	redisAddr := os.Getenv("REDIS_ADDR")
+	if redisAddr == "" {
+		redisAddr = "redis:6379"
+	}
+	redisOpts := &redis.Options{Addr: redisAddr}
+	if sandbox {
+		redisOpts.DB = 1
+	}
+	rdb := redis.NewClient(redisOpts)
+
 	// Setup router
-	r := gin.Default()
+	r := gin.New()
+	r.Use(gin.Logger(), middleware.PanicRecovery("order-history", rdb))
 
 	// API Routes
 	api := r.Group("/api/v1")
@@ -82,8 +106,190 @@ func main() {
 		})
 	})
 
+	// Unified activity feed: trades and wallet movements merged newest-first.
+	// Registered at the gateway-facing path so the proxy forwards it as-is.
+	r.GET("/transaction/getRecentActivity", middleware.TokenAuthMiddleware(), func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		limit := 20
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"data":    nil,
+					"message": "limit must be between 1 and 100",
+				})
+				return
+			}
+			limit = n
+		}
+
+		activity, err := txService.GetRecentActivity(c.Request.Context(), userID, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get recent activity: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": activity})
+	})
+
+	// Fill chain tracing. The flat variant lists one order's direct children;
+	// the tree variant recursively follows the parent chain from a root.
+	// Registered at the gateway-facing paths so the proxy forwards them as-is.
+	r.GET("/transaction/getStockTransactionsByParent", middleware.TokenAuthMiddleware(), func(c *gin.Context) {
+		parentTxID := c.Query("parent_tx_id")
+		if parentTxID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "parent_tx_id is required",
+			})
+			return
+		}
+
+		stockTxs, err := txService.GetStockTransactionsByParent(c.Request.Context(), parentTxID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get child transactions: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": stockTxs})
+	})
+
+	r.GET("/transaction/getTransactionTree", middleware.TokenAuthMiddleware(), func(c *gin.Context) {
+		rootTxID := c.Query("root_tx_id")
+		if rootTxID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "root_tx_id is required",
+			})
+			return
+		}
+
+		stockTxs, err := txService.GetTransactionTree(c.Request.Context(), rootTxID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get transaction tree: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": stockTxs})
+	})
+
+	// Transaction history export. Streams CSV (format=csv) or returns the
+	// standard JSON response (format=json). Capped at maxExportRows rows.
+	const maxExportRows = 10000
+	r.GET("/exportTransactions", middleware.TokenAuthMiddleware(), func(c *gin.Context) {
+		userID := c.GetString("userID")
+
+		from := time.Now().AddDate(0, -1, 0)
+		to := time.Now()
+		if v := c.Query("from"); v != "" {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				from = t
+			}
+		}
+		if v := c.Query("to"); v != "" {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				to = t.AddDate(0, 0, 1) // inclusive end date
+			}
+		}
+
+		count, err := txService.CountStockTransactions(c.Request.Context(), userID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to count transactions: %v", err),
+			})
+			return
+		}
+		if count > maxExportRows {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Export would return %d rows, limit is %d. Narrow the date range.", count, maxExportRows),
+			})
+			return
+		}
+
+		txs, err := txService.GetStockTransactionsInRange(c.Request.Context(), userID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get transactions: %v", err),
+			})
+			return
+		}
+
+		if c.DefaultQuery("format", "json") != "csv" {
+			c.JSON(http.StatusOK, gin.H{"success": true, "data": txs})
+			return
+		}
+
+		filename := fmt.Sprintf("transactions_%s_%s.csv", userID, time.Now().Format("2006-01-02"))
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+		w := csv.NewWriter(c.Writer)
+		_ = w.Write([]string{
+			"stock_tx_id", "parent_stock_tx_id", "stock_id", "order_type", "is_buy",
+			"quantity", "stock_price", "total_value", "order_status", "timestamp",
+		})
+		w.Flush()
+		for _, tx := range txs {
+			parent := ""
+			if tx.ParentStockTxID != nil {
+				parent = *tx.ParentStockTxID
+			}
+			_ = w.Write([]string{
+				tx.StockTxID,
+				parent,
+				tx.StockID,
+				tx.OrderType,
+				strconv.FormatBool(tx.IsBuy),
+				strconv.Itoa(tx.Quantity),
+				strconv.FormatFloat(tx.StockPrice, 'f', 2, 64),
+				strconv.FormatFloat(tx.StockPrice*float64(tx.Quantity), 'f', 2, 64),
+				tx.OrderStatus,
+				tx.TimeStamp.Format(time.RFC3339),
+			})
+			w.Flush() // stream row by row
+		}
+	})
+
 	// Internal API for other services - not authenticated
 	internal := r.Group("/internal")
+
+	// Clean-slate reset for CI suites; never registered outside sandbox mode
+	if sandbox {
+		internal.DELETE("/sandbox/reset", func(c *gin.Context) {
+			if err := txService.ResetSandboxData(c.Request.Context()); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"success": false,
+					"data":    nil,
+					"message": fmt.Sprintf("Failed to reset sandbox data: %v", err),
+				})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"success": true, "data": nil})
+		})
+	}
+
 	internal.POST("/recordStockTransaction", func(c *gin.Context) {
 		var tx models.StockTransaction
 		if err := c.ShouldBindJSON(&tx); err != nil {
@@ -136,6 +342,372 @@ func main() {
 		})
 	})
 
+	internal.GET("/getTransaction", func(c *gin.Context) {
+		stockTxID := c.Query("stock_tx_id")
+		if stockTxID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "stock_tx_id is required",
+			})
+			return
+		}
+
+		tx, err := txService.GetStockTransactionByID(c.Request.Context(), stockTxID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get transaction: %v", err),
+			})
+			return
+		}
+		if tx == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "Transaction not found",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": tx})
+	})
+
+	internal.POST("/syncOrderStatus", func(c *gin.Context) {
+		var txs []models.StockTransaction
+		if err := c.ShouldBindJSON(&txs); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+
+		inserted, updated, err := txService.SyncStockTransactions(c.Request.Context(), txs)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to sync transactions: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    gin.H{"inserted": inserted, "updated": updated},
+		})
+	})
+
+	internal.GET("/getTransactionByWalletTx", func(c *gin.Context) {
+		walletTxID := c.Query("wallet_tx_id")
+		if walletTxID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "wallet_tx_id is required",
+			})
+			return
+		}
+
+		tx, err := txService.GetStockTransactionByWalletTx(c.Request.Context(), walletTxID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get transaction: %v", err),
+			})
+			return
+		}
+		if tx == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "No transaction found for wallet_tx_id",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": tx})
+	})
+
+	internal.POST("/recordFailedTrade", func(c *gin.Context) {
+		var ft models.FailedTrade
+		if err := c.ShouldBindJSON(&ft); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+
+		if err := txService.RecordFailedTrade(c.Request.Context(), &ft); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to record failed trade: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": nil})
+	})
+
+	// Admin view of failed trade compensations. Replays are driven by the
+	// matching service, which looks up the row here, re-issues the wallet
+	// calls, and then posts back to resolveFailedTrade.
+	internal.GET("/admin/failedTrades", func(c *gin.Context) {
+		includeResolved := c.Query("include_resolved") == "true"
+
+		trades, err := txService.GetFailedTrades(c.Request.Context(), includeResolved)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get failed trades: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": trades})
+	})
+
+	internal.GET("/admin/failedTrade", func(c *gin.Context) {
+		stockTxID := c.Query("stock_tx_id")
+		failedStep := c.Query("failed_step")
+		if stockTxID == "" || failedStep == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "stock_tx_id and failed_step are required",
+			})
+			return
+		}
+
+		ft, err := txService.GetFailedTrade(c.Request.Context(), stockTxID, failedStep)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get failed trade: %v", err),
+			})
+			return
+		}
+		if ft == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "No unresolved failed trade found",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": ft})
+	})
+
+	internal.POST("/admin/resolveFailedTrade", func(c *gin.Context) {
+		var req struct {
+			StockTxID  string `json:"stock_tx_id"`
+			FailedStep string `json:"failed_step"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || req.StockTxID == "" || req.FailedStep == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "stock_tx_id and failed_step are required",
+			})
+			return
+		}
+
+		resolved, err := txService.ResolveFailedTrade(c.Request.Context(), req.StockTxID, req.FailedStep)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to resolve failed trade: %v", err),
+			})
+			return
+		}
+		if !resolved {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "No unresolved failed trade found",
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": nil})
+	})
+
+	internal.POST("/recordMarketData", func(c *gin.Context) {
+		var req struct {
+			StockID  int     `json:"stock_id"`
+			Price    float64 `json:"price"`
+			Quantity int     `json:"quantity"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Invalid request: %v", err),
+			})
+			return
+		}
+
+		if err := txService.RecordTrade(c.Request.Context(), req.StockID, req.Price, req.Quantity); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to record market data: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": nil})
+	})
+
+	// Daily OHLCV summary per stock (public market data)
+	r.GET("/getMarketData", func(c *gin.Context) {
+		stockID, err := strconv.Atoi(c.Query("stock_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "Invalid stock_id",
+			})
+			return
+		}
+
+		from := time.Now().AddDate(0, 0, -30)
+		to := time.Now()
+		if v := c.Query("from"); v != "" {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				from = t
+			}
+		}
+		if v := c.Query("to"); v != "" {
+			if t, err := time.Parse("2006-01-02", v); err == nil {
+				to = t
+			}
+		}
+
+		data, err := txService.GetMarketData(c.Request.Context(), stockID, from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get market data: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": data})
+	})
+
+	// Rolling 24h stats per stock (last price, change, volume, high/low).
+	// Computed in one TimescaleDB aggregate and cached in Redis for 60s since
+	// every ticker widget polls it. Proxied through the gateway's
+	// authenticated /engine group.
+	r.GET("/engine/getStockStats", func(c *gin.Context) {
+		stockID, err := strconv.Atoi(c.Query("stock_id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "Invalid stock_id",
+			})
+			return
+		}
+
+		cacheKey := fmt.Sprintf("stock_stats:%d", stockID)
+		if raw, err := rdb.Get(c.Request.Context(), cacheKey).Result(); err == nil {
+			c.JSON(http.StatusOK, gin.H{"success": true, "data": json.RawMessage(raw)})
+			return
+		}
+
+		stats, err := txService.GetStockStats(c.Request.Context(), stockID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get stock stats: %v", err),
+			})
+			return
+		}
+		if stats == nil {
+			c.JSON(http.StatusNotFound, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "No trades in the last 24 hours",
+			})
+			return
+		}
+
+		if payload, err := json.Marshal(stats); err == nil {
+			if err := rdb.Set(c.Request.Context(), cacheKey, payload, 60*time.Second).Err(); err != nil {
+				log.Println("Warning: could not cache stock stats:", err)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": stats})
+	})
+
+	// Public trade tape per stock: executed trades without user IDs, newest
+	// first. Proxied through the gateway without authentication.
+	r.GET("/engine/tradeHistory", func(c *gin.Context) {
+		stockID := c.Query("stock_id")
+		if stockID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": "stock_id is required",
+			})
+			return
+		}
+
+		limit := 100
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 1000 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"success": false,
+					"data":    nil,
+					"message": "limit must be between 1 and 1000",
+				})
+				return
+			}
+			limit = n
+		}
+
+		from := time.Now().AddDate(0, 0, -1)
+		to := time.Now()
+		if v := c.Query("from"); v != "" {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				from = t
+			}
+		}
+		if v := c.Query("to"); v != "" {
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				to = t
+			}
+		}
+
+		trades, err := txService.GetTradeHistory(c.Request.Context(), stockID, from, to, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"success": false,
+				"data":    nil,
+				"message": fmt.Sprintf("Failed to get trade history: %v", err),
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": trades})
+	})
+
 	// Health check
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -144,6 +716,19 @@ func main() {
 		})
 	})
 
+	// Kubernetes-style probes: liveness answers immediately, readiness
+	// verifies the TimescaleDB pool is reachable.
+	r.GET("/health/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "alive"})
+	})
+	r.GET("/health/ready", func(c *gin.Context) {
+		if err := dbHandler.GetDB().Ping(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "database": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ready"})
+	})
+
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
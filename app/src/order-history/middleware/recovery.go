@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// PanicRecovery turns a panicking handler into a 500 response instead of a
+// dead process. The panic value and the top of the stack are logged and
+// published as a PANIC alert on the shared Redis "service-alerts" channel so
+// operators hear about it without tailing this service's logs.
+func PanicRecovery(serviceName string, rdb *redis.Client) gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		stack := string(debug.Stack())
+		log.Printf("PANIC in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, recovered, stack)
+
+		lines := strings.Split(stack, "\n")
+		if len(lines) > 20 {
+			lines = lines[:20]
+		}
+		alert, _ := json.Marshal(gin.H{
+			"type":         "PANIC",
+			"service_name": serviceName,
+			"panic_value":  fmt.Sprintf("%v", recovered),
+			"stack":        strings.Join(lines, "\n"),
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		if err := rdb.Publish(context.Background(), "service-alerts", alert).Err(); err != nil {
+			log.Printf("Warning: could not publish panic alert: %v", err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
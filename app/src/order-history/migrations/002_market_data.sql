@@ -0,0 +1,16 @@
+-- Daily OHLCV market data, one row per stock per day.
+-- Populated by the matching service after each executed trade.
+CREATE TABLE IF NOT EXISTS daily_market_data (
+    date DATE NOT NULL,
+    stock_id INTEGER NOT NULL,
+    open_price DECIMAL(18, 2) NOT NULL,
+    high_price DECIMAL(18, 2) NOT NULL,
+    low_price DECIMAL(18, 2) NOT NULL,
+    close_price DECIMAL(18, 2) NOT NULL,
+    total_volume INTEGER NOT NULL DEFAULT 0,
+    trade_count INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (date, stock_id)
+);
+
+-- Create index on stock_id for per-stock range queries
+CREATE INDEX IF NOT EXISTS idx_daily_market_data_stock ON daily_market_data(stock_id, date);
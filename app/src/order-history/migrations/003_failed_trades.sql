@@ -0,0 +1,20 @@
+-- Failed trade compensations. The matching service records a row whenever a
+-- compensation step of a trade saga fails (e.g. wallet-service was down), so
+-- an operator can replay the step later instead of leaving the books skewed.
+CREATE TABLE IF NOT EXISTS failed_trades (
+    stock_tx_id VARCHAR(36) NOT NULL,
+    stock_id INTEGER NOT NULL,
+    buy_user_id INTEGER NOT NULL,
+    sell_user_id INTEGER NOT NULL,
+    quantity INTEGER NOT NULL,
+    price DECIMAL(18, 2) NOT NULL,
+    failed_step VARCHAR(40) NOT NULL,
+    error_message TEXT,
+    resolved BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMPTZ,
+    PRIMARY KEY (stock_tx_id, failed_step)
+);
+
+-- Create index for listing unresolved failures
+CREATE INDEX IF NOT EXISTS idx_failed_trades_unresolved ON failed_trades(resolved, created_at);
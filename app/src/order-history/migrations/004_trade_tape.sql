@@ -0,0 +1,7 @@
+-- Public trade tape support. aggressor_side records which side initiated a
+-- fill (the later-arriving order); rows written before this migration keep a
+-- NULL and are served without the field.
+ALTER TABLE stock_transactions ADD COLUMN IF NOT EXISTS aggressor_side VARCHAR(4);
+
+-- Index for the per-stock tape query (time-descending scan)
+CREATE INDEX IF NOT EXISTS idx_stock_tx_stock_time ON stock_transactions(stock_id, time_stamp DESC);
@@ -0,0 +1,4 @@
+-- Partial fills are linked to their originating order through
+-- parent_stock_tx_id; tracing a fill chain needs an index on that column or
+-- every lookup walks the whole table.
+CREATE INDEX IF NOT EXISTS idx_stock_tx_parent ON stock_transactions(parent_stock_tx_id);
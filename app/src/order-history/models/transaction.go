@@ -16,9 +16,70 @@ type StockTransaction struct {
 	Quantity        int       `json:"quantity"`
 	BuyerID         *string   `json:"buyer_id,omitempty"`
 	SellerID        *string   `json:"seller_id,omitempty"`
+	AggressorSide   *string   `json:"aggressor_side,omitempty"`
 	TimeStamp       time.Time `json:"time_stamp"`
 }
 
+// TradeTapeEntry is one executed trade on the public tape. User IDs are
+// deliberately omitted.
+type TradeTapeEntry struct {
+	Price         float64   `json:"price"`
+	Quantity      int       `json:"quantity"`
+	Timestamp     time.Time `json:"timestamp"`
+	AggressorSide string    `json:"aggressor_side,omitempty"`
+}
+
+type FailedTrade struct {
+	StockTxID    string     `json:"stock_tx_id"`
+	StockID      int        `json:"stock_id"`
+	BuyUserID    int        `json:"buy_user_id"`
+	SellUserID   int        `json:"sell_user_id"`
+	Quantity     int        `json:"quantity"`
+	Price        float64    `json:"price"`
+	FailedStep   string     `json:"failed_step"`
+	ErrorMessage string     `json:"error_message"`
+	Resolved     bool       `json:"resolved"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+}
+
+// StockStats is a rolling 24-hour summary for one stock, derived from the
+// completed trades in stock_transactions.
+type StockStats struct {
+	StockID       int     `json:"stock_id"`
+	CurrentPrice  float64 `json:"current_price"`
+	Price24hAgo   float64 `json:"price_24h_ago"`
+	ChangePct     float64 `json:"change_pct"`
+	Volume24h     int     `json:"volume_24h"`
+	High24h       float64 `json:"high_24h"`
+	Low24h        float64 `json:"low_24h"`
+	TradeCount24h int     `json:"trade_count_24h"`
+}
+
+type MarketData struct {
+	Date        time.Time `json:"date"`
+	StockID     int       `json:"stock_id"`
+	OpenPrice   float64   `json:"open_price"`
+	HighPrice   float64   `json:"high_price"`
+	LowPrice    float64   `json:"low_price"`
+	ClosePrice  float64   `json:"close_price"`
+	TotalVolume int       `json:"total_volume"`
+	TradeCount  int       `json:"trade_count"`
+}
+
+// ActivityItem is one entry in the unified activity feed: either a trade
+// (from stock_transactions) or a wallet movement (from wallet_transactions).
+// The stock fields are only set for trades.
+type ActivityItem struct {
+	ID           string    `json:"id"`
+	ActivityType string    `json:"activity_type"`
+	Amount       float64   `json:"amount"`
+	StockID      *string   `json:"stock_id,omitempty"`
+	Quantity     *int      `json:"quantity,omitempty"`
+	Price        *float64  `json:"price,omitempty"`
+	TimeStamp    time.Time `json:"time_stamp"`
+}
+
 type WalletTransaction struct {
 	WalletTxID string    `json:"wallet_tx_id"`
 	StockTxID  string    `json:"stock_tx_id"`
@@ -2,13 +2,16 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"main/database"
 	"main/models"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 )
 
 type TransactionService struct {
@@ -21,6 +24,17 @@ func NewTransactionService(db *database.TimescaleDBHandler) *TransactionService
 	}
 }
 
+// ResetSandboxData truncates every history table. Only reachable through
+// the sandbox reset endpoint.
+func (s *TransactionService) ResetSandboxData(ctx context.Context) error {
+	_, err := s.db.GetDB().Exec(ctx,
+		`TRUNCATE TABLE stock_transactions, wallet_transactions, daily_market_data, failed_trades`)
+	if err != nil {
+		return fmt.Errorf("failed to truncate sandbox tables: %w", err)
+	}
+	return nil
+}
+
 func (s *TransactionService) RecordStockTransaction(ctx context.Context, tx *models.StockTransaction) error {
 	// If there's no time stamp, set it to now
 	if tx.TimeStamp.IsZero() {
@@ -34,11 +48,11 @@ func (s *TransactionService) RecordStockTransaction(ctx context.Context, tx *mod
 
 	query := `
 		INSERT INTO stock_transactions (
-			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id, 
-			order_status, is_buy, order_type, stock_price, 
-			quantity, buyer_id, seller_id, time_stamp
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, aggressor_side, time_stamp
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
 		) ON CONFLICT (stock_tx_id) DO UPDATE SET
 			parent_stock_tx_id = EXCLUDED.parent_stock_tx_id,
 			wallet_tx_id = EXCLUDED.wallet_tx_id,
@@ -49,13 +63,14 @@ func (s *TransactionService) RecordStockTransaction(ctx context.Context, tx *mod
 			quantity = EXCLUDED.quantity,
 			buyer_id = EXCLUDED.buyer_id,
 			seller_id = EXCLUDED.seller_id,
+			aggressor_side = EXCLUDED.aggressor_side,
 			time_stamp = EXCLUDED.time_stamp
 	`
 
 	_, err := s.db.GetDB().Exec(ctx, query,
 		tx.StockTxID, tx.ParentStockTxID, tx.StockID, tx.WalletTxID,
 		tx.OrderStatus, tx.IsBuy, tx.OrderType, tx.StockPrice,
-		tx.Quantity, tx.BuyerID, tx.SellerID, tx.TimeStamp,
+		tx.Quantity, tx.BuyerID, tx.SellerID, tx.AggressorSide, tx.TimeStamp,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to record stock transaction: %w", err)
@@ -100,6 +115,120 @@ func (s *TransactionService) RecordWalletTransaction(ctx context.Context, tx *mo
 	return nil
 }
 
+// SyncStockTransactions upserts a reconciliation batch of stock transactions
+// and reports how many rows were newly inserted versus updated. The upsert is
+// the same ON CONFLICT DO UPDATE as RecordStockTransaction; `xmax = 0`
+// distinguishes an insert from an update of an existing row.
+func (s *TransactionService) SyncStockTransactions(ctx context.Context, txs []models.StockTransaction) (inserted, updated int, err error) {
+	query := `
+		INSERT INTO stock_transactions (
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, aggressor_side, time_stamp
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13
+		) ON CONFLICT (stock_tx_id) DO UPDATE SET
+			parent_stock_tx_id = EXCLUDED.parent_stock_tx_id,
+			wallet_tx_id = EXCLUDED.wallet_tx_id,
+			order_status = EXCLUDED.order_status,
+			is_buy = EXCLUDED.is_buy,
+			order_type = EXCLUDED.order_type,
+			stock_price = EXCLUDED.stock_price,
+			quantity = EXCLUDED.quantity,
+			buyer_id = EXCLUDED.buyer_id,
+			seller_id = EXCLUDED.seller_id,
+			aggressor_side = EXCLUDED.aggressor_side,
+			time_stamp = EXCLUDED.time_stamp
+		RETURNING (xmax = 0) AS was_inserted
+	`
+
+	for i := range txs {
+		tx := &txs[i]
+		if tx.TimeStamp.IsZero() {
+			tx.TimeStamp = time.Now().UTC()
+		}
+		if tx.StockTxID == "" {
+			return inserted, updated, errors.New("sync batch contains a transaction without stock_tx_id")
+		}
+
+		var wasInserted bool
+		err := s.db.GetDB().QueryRow(ctx, query,
+			tx.StockTxID, tx.ParentStockTxID, tx.StockID, tx.WalletTxID,
+			tx.OrderStatus, tx.IsBuy, tx.OrderType, tx.StockPrice,
+			tx.Quantity, tx.BuyerID, tx.SellerID, tx.AggressorSide, tx.TimeStamp,
+		).Scan(&wasInserted)
+		if err != nil {
+			return inserted, updated, fmt.Errorf("failed to sync stock transaction %s: %w", tx.StockTxID, err)
+		}
+		if wasInserted {
+			inserted++
+		} else {
+			updated++
+		}
+	}
+	return inserted, updated, nil
+}
+
+// RecordTrade upserts one executed trade into the daily OHLCV summary. The
+// first trade of the day seeds all four prices; later trades only raise the
+// high, lower the low, move the close, and accumulate volume.
+func (s *TransactionService) RecordTrade(ctx context.Context, stockID int, price float64, quantity int) error {
+	query := `
+		INSERT INTO daily_market_data (
+			date, stock_id, open_price, high_price, low_price, close_price,
+			total_volume, trade_count
+		) VALUES (
+			CURRENT_DATE, $1, $2, $2, $2, $2, $3, 1
+		) ON CONFLICT (date, stock_id) DO UPDATE SET
+			high_price = GREATEST(daily_market_data.high_price, EXCLUDED.close_price),
+			low_price = LEAST(daily_market_data.low_price, EXCLUDED.close_price),
+			close_price = EXCLUDED.close_price,
+			total_volume = daily_market_data.total_volume + EXCLUDED.total_volume,
+			trade_count = daily_market_data.trade_count + 1
+	`
+
+	_, err := s.db.GetDB().Exec(ctx, query, stockID, price, quantity)
+	if err != nil {
+		return fmt.Errorf("failed to record market data: %w", err)
+	}
+	return nil
+}
+
+// GetMarketData returns the daily OHLCV rows for a stock in a date range.
+func (s *TransactionService) GetMarketData(ctx context.Context, stockID int, from, to time.Time) ([]models.MarketData, error) {
+	query := `
+		SELECT date, stock_id, open_price, high_price, low_price, close_price,
+			total_volume, trade_count
+		FROM daily_market_data
+		WHERE stock_id = $1 AND date BETWEEN $2 AND $3
+		ORDER BY date ASC
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, stockID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query market data: %w", err)
+	}
+	defer rows.Close()
+
+	var data []models.MarketData
+	for rows.Next() {
+		var md models.MarketData
+		if err := rows.Scan(
+			&md.Date, &md.StockID, &md.OpenPrice, &md.HighPrice, &md.LowPrice,
+			&md.ClosePrice, &md.TotalVolume, &md.TradeCount,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan market data: %w", err)
+		}
+		data = append(data, md)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over market data: %w", err)
+	}
+
+	return data, nil
+}
+
 func (s *TransactionService) GetStockTransactions(ctx context.Context, userID string) ([]models.StockTransaction, error) {
 	query := `
 		SELECT 
@@ -140,6 +269,466 @@ func (s *TransactionService) GetStockTransactions(ctx context.Context, userID st
 	return transactions, nil
 }
 
+// GetStockTransactionsByParent returns every transaction recorded against a
+// parent transaction ID, oldest first - the direct children of one order's
+// fill chain.
+func (s *TransactionService) GetStockTransactionsByParent(ctx context.Context, parentTxID string) ([]models.StockTransaction, error) {
+	query := `
+		SELECT
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, time_stamp
+		FROM
+			stock_transactions
+		WHERE
+			parent_stock_tx_id = $1
+		ORDER BY
+			time_stamp ASC
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, parentTxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query child transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.StockTransaction
+	for rows.Next() {
+		var tx models.StockTransaction
+		if err := rows.Scan(
+			&tx.StockTxID, &tx.ParentStockTxID, &tx.StockID, &tx.WalletTxID,
+			&tx.OrderStatus, &tx.IsBuy, &tx.OrderType, &tx.StockPrice,
+			&tx.Quantity, &tx.BuyerID, &tx.SellerID, &tx.TimeStamp,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan stock transaction: %w", err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over stock transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// GetTransactionTree follows the parent chain recursively from a root
+// transaction: the root's children, their children, and so on. Useful when
+// fills spawn sub-fills of partial fills. Depth is capped at 10 levels so a
+// cyclic parent reference cannot loop forever.
+func (s *TransactionService) GetTransactionTree(ctx context.Context, rootTxID string) ([]models.StockTransaction, error) {
+	query := `
+		WITH RECURSIVE tx_tree AS (
+			SELECT
+				stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+				order_status, is_buy, order_type, stock_price,
+				quantity, buyer_id, seller_id, time_stamp, 0 AS depth
+			FROM stock_transactions
+			WHERE stock_tx_id = $1
+			UNION ALL
+			SELECT
+				st.stock_tx_id, st.parent_stock_tx_id, st.stock_id, st.wallet_tx_id,
+				st.order_status, st.is_buy, st.order_type, st.stock_price,
+				st.quantity, st.buyer_id, st.seller_id, st.time_stamp, tx_tree.depth + 1
+			FROM stock_transactions st
+			JOIN tx_tree ON st.parent_stock_tx_id = tx_tree.stock_tx_id
+			WHERE tx_tree.depth < 10
+		)
+		SELECT
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, time_stamp
+		FROM tx_tree
+		ORDER BY depth ASC, time_stamp ASC
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, rootTxID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transaction tree: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.StockTransaction
+	for rows.Next() {
+		var tx models.StockTransaction
+		if err := rows.Scan(
+			&tx.StockTxID, &tx.ParentStockTxID, &tx.StockID, &tx.WalletTxID,
+			&tx.OrderStatus, &tx.IsBuy, &tx.OrderType, &tx.StockPrice,
+			&tx.Quantity, &tx.BuyerID, &tx.SellerID, &tx.TimeStamp,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan stock transaction: %w", err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over stock transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// GetStockTransactionByID returns one stock transaction, or nil if the ID is
+// unknown.
+func (s *TransactionService) GetStockTransactionByID(ctx context.Context, stockTxID string) (*models.StockTransaction, error) {
+	query := `
+		SELECT
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, time_stamp
+		FROM
+			stock_transactions
+		WHERE
+			stock_tx_id = $1
+	`
+
+	var tx models.StockTransaction
+	err := s.db.GetDB().QueryRow(ctx, query, stockTxID).Scan(
+		&tx.StockTxID, &tx.ParentStockTxID, &tx.StockID, &tx.WalletTxID,
+		&tx.OrderStatus, &tx.IsBuy, &tx.OrderType, &tx.StockPrice,
+		&tx.Quantity, &tx.BuyerID, &tx.SellerID, &tx.TimeStamp,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query stock transaction: %w", err)
+	}
+	return &tx, nil
+}
+
+// GetStockTransactionByWalletTx returns the stock transaction linked to a
+// wallet transaction, or nil if none is.
+func (s *TransactionService) GetStockTransactionByWalletTx(ctx context.Context, walletTxID string) (*models.StockTransaction, error) {
+	query := `
+		SELECT
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, time_stamp
+		FROM
+			stock_transactions
+		WHERE
+			wallet_tx_id = $1
+	`
+
+	var tx models.StockTransaction
+	err := s.db.GetDB().QueryRow(ctx, query, walletTxID).Scan(
+		&tx.StockTxID, &tx.ParentStockTxID, &tx.StockID, &tx.WalletTxID,
+		&tx.OrderStatus, &tx.IsBuy, &tx.OrderType, &tx.StockPrice,
+		&tx.Quantity, &tx.BuyerID, &tx.SellerID, &tx.TimeStamp,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query stock transaction by wallet_tx_id: %w", err)
+	}
+	return &tx, nil
+}
+
+// GetTradeHistory returns the public trade tape for a stock: executed trades
+// in a time range, newest first, with no user identifiers.
+func (s *TransactionService) GetTradeHistory(ctx context.Context, stockID string, from, to time.Time, limit int) ([]models.TradeTapeEntry, error) {
+	query := `
+		SELECT
+			stock_price, quantity, time_stamp, COALESCE(aggressor_side, '')
+		FROM
+			stock_transactions
+		WHERE
+			stock_id = $1 AND order_status = 'COMPLETED' AND time_stamp BETWEEN $2 AND $3
+		ORDER BY
+			time_stamp DESC
+		LIMIT $4
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, stockID, from, to, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trade history: %w", err)
+	}
+	defer rows.Close()
+
+	trades := []models.TradeTapeEntry{}
+	for rows.Next() {
+		var t models.TradeTapeEntry
+		if err := rows.Scan(&t.Price, &t.Quantity, &t.Timestamp, &t.AggressorSide); err != nil {
+			return nil, fmt.Errorf("failed to scan trade: %w", err)
+		}
+		trades = append(trades, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over trade history: %w", err)
+	}
+
+	return trades, nil
+}
+
+// GetStockStats computes a stock's rolling 24-hour statistics in one
+// aggregate over the stock_transactions hypertable. TimescaleDB's first/last
+// give the oldest and newest trade price inside the window, so "price 24h
+// ago" is really the earliest trade of the window - exact enough for a
+// change-percentage display. Returns nil when the stock has no trades in the
+// window.
+func (s *TransactionService) GetStockStats(ctx context.Context, stockID int) (*models.StockStats, error) {
+	query := `
+		SELECT
+			COALESCE(last(stock_price, time_stamp), 0),
+			COALESCE(first(stock_price, time_stamp), 0),
+			COALESCE(SUM(quantity), 0),
+			COALESCE(MAX(stock_price), 0),
+			COALESCE(MIN(stock_price), 0),
+			COUNT(*)
+		FROM
+			stock_transactions
+		WHERE
+			stock_id = $1 AND order_status = 'COMPLETED'
+			AND time_stamp > now() - INTERVAL '24 hours'
+	`
+
+	stats := models.StockStats{StockID: stockID}
+	err := s.db.GetDB().QueryRow(ctx, query, strconv.Itoa(stockID)).Scan(
+		&stats.CurrentPrice, &stats.Price24hAgo, &stats.Volume24h,
+		&stats.High24h, &stats.Low24h, &stats.TradeCount24h)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stock stats: %w", err)
+	}
+	if stats.TradeCount24h == 0 {
+		return nil, nil
+	}
+	if stats.Price24hAgo > 0 {
+		stats.ChangePct = (stats.CurrentPrice - stats.Price24hAgo) / stats.Price24hAgo * 100
+	}
+	return &stats, nil
+}
+
+// CountStockTransactions returns how many stock transactions a user has in a
+// time range, so export endpoints can refuse oversized requests up front.
+func (s *TransactionService) CountStockTransactions(ctx context.Context, userID string, from, to time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*)
+		FROM stock_transactions
+		WHERE (buyer_id = $1 OR seller_id = $1) AND time_stamp BETWEEN $2 AND $3
+	`
+
+	var count int
+	if err := s.db.GetDB().QueryRow(ctx, query, userID, from, to).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count stock transactions: %w", err)
+	}
+	return count, nil
+}
+
+// GetStockTransactionsInRange returns a user's stock transactions between two
+// timestamps, oldest first.
+func (s *TransactionService) GetStockTransactionsInRange(ctx context.Context, userID string, from, to time.Time) ([]models.StockTransaction, error) {
+	query := `
+		SELECT
+			stock_tx_id, parent_stock_tx_id, stock_id, wallet_tx_id,
+			order_status, is_buy, order_type, stock_price,
+			quantity, buyer_id, seller_id, time_stamp
+		FROM
+			stock_transactions
+		WHERE
+			(buyer_id = $1 OR seller_id = $1) AND time_stamp BETWEEN $2 AND $3
+		ORDER BY
+			time_stamp ASC
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, userID, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stock transactions: %w", err)
+	}
+	defer rows.Close()
+
+	var transactions []models.StockTransaction
+	for rows.Next() {
+		var tx models.StockTransaction
+		if err := rows.Scan(
+			&tx.StockTxID, &tx.ParentStockTxID, &tx.StockID, &tx.WalletTxID,
+			&tx.OrderStatus, &tx.IsBuy, &tx.OrderType, &tx.StockPrice,
+			&tx.Quantity, &tx.BuyerID, &tx.SellerID, &tx.TimeStamp,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan stock transaction: %w", err)
+		}
+		transactions = append(transactions, tx)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over stock transactions: %w", err)
+	}
+
+	return transactions, nil
+}
+
+// GetRecentActivity merges a user's stock and wallet transactions into one
+// time-ordered feed, newest first. Trades are tagged TRADE; wallet movements
+// are tagged WITHDRAWAL or DEPOSIT by their debit flag.
+func (s *TransactionService) GetRecentActivity(ctx context.Context, userID string, limit int) ([]models.ActivityItem, error) {
+	query := `
+		SELECT id, activity_type, amount, stock_id, quantity, price, time_stamp
+		FROM (
+			SELECT
+				stock_tx_id AS id,
+				'TRADE' AS activity_type,
+				stock_price * quantity AS amount,
+				stock_id,
+				quantity,
+				stock_price AS price,
+				time_stamp
+			FROM stock_transactions
+			WHERE buyer_id = $1 OR seller_id = $1
+			UNION ALL
+			SELECT
+				wallet_tx_id AS id,
+				CASE WHEN is_debit THEN 'WITHDRAWAL' ELSE 'DEPOSIT' END AS activity_type,
+				amount,
+				NULL AS stock_id,
+				NULL AS quantity,
+				NULL AS price,
+				time_stamp
+			FROM wallet_transactions
+			WHERE user_id = $1
+		) activity
+		ORDER BY time_stamp DESC
+		LIMIT $2
+	`
+
+	rows, err := s.db.GetDB().Query(ctx, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent activity: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.ActivityItem{}
+	for rows.Next() {
+		var item models.ActivityItem
+		if err := rows.Scan(
+			&item.ID, &item.ActivityType, &item.Amount,
+			&item.StockID, &item.Quantity, &item.Price, &item.TimeStamp,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan activity item: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over recent activity: %w", err)
+	}
+
+	return items, nil
+}
+
+// RecordFailedTrade upserts a failed compensation step reported by the
+// matching service. Re-reporting the same (stock_tx_id, failed_step) pair
+// refreshes the error message instead of duplicating the row.
+func (s *TransactionService) RecordFailedTrade(ctx context.Context, ft *models.FailedTrade) error {
+	if ft.CreatedAt.IsZero() {
+		ft.CreatedAt = time.Now().UTC()
+	}
+
+	query := `
+		INSERT INTO failed_trades (
+			stock_tx_id, stock_id, buy_user_id, sell_user_id,
+			quantity, price, failed_step, error_message, created_at
+		) VALUES (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9
+		) ON CONFLICT (stock_tx_id, failed_step) DO UPDATE SET
+			error_message = EXCLUDED.error_message,
+			resolved = FALSE,
+			resolved_at = NULL
+	`
+
+	_, err := s.db.GetDB().Exec(ctx, query,
+		ft.StockTxID, ft.StockID, ft.BuyUserID, ft.SellUserID,
+		ft.Quantity, ft.Price, ft.FailedStep, ft.ErrorMessage, ft.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record failed trade: %w", err)
+	}
+
+	return nil
+}
+
+// GetFailedTrades lists recorded compensation failures, oldest first. When
+// includeResolved is false only outstanding failures are returned.
+func (s *TransactionService) GetFailedTrades(ctx context.Context, includeResolved bool) ([]models.FailedTrade, error) {
+	query := `
+		SELECT stock_tx_id, stock_id, buy_user_id, sell_user_id,
+			quantity, price, failed_step, COALESCE(error_message, ''),
+			resolved, created_at, resolved_at
+		FROM failed_trades
+	`
+	if !includeResolved {
+		query += " WHERE resolved = FALSE"
+	}
+	query += " ORDER BY created_at ASC"
+
+	rows, err := s.db.GetDB().Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query failed trades: %w", err)
+	}
+	defer rows.Close()
+
+	var trades []models.FailedTrade
+	for rows.Next() {
+		var ft models.FailedTrade
+		if err := rows.Scan(
+			&ft.StockTxID, &ft.StockID, &ft.BuyUserID, &ft.SellUserID,
+			&ft.Quantity, &ft.Price, &ft.FailedStep, &ft.ErrorMessage,
+			&ft.Resolved, &ft.CreatedAt, &ft.ResolvedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan failed trade: %w", err)
+		}
+		trades = append(trades, ft)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over failed trades: %w", err)
+	}
+
+	return trades, nil
+}
+
+// GetFailedTrade returns a single unresolved failure, or nil if none matches.
+func (s *TransactionService) GetFailedTrade(ctx context.Context, stockTxID, failedStep string) (*models.FailedTrade, error) {
+	query := `
+		SELECT stock_tx_id, stock_id, buy_user_id, sell_user_id,
+			quantity, price, failed_step, COALESCE(error_message, ''),
+			resolved, created_at, resolved_at
+		FROM failed_trades
+		WHERE stock_tx_id = $1 AND failed_step = $2 AND resolved = FALSE
+	`
+
+	var ft models.FailedTrade
+	err := s.db.GetDB().QueryRow(ctx, query, stockTxID, failedStep).Scan(
+		&ft.StockTxID, &ft.StockID, &ft.BuyUserID, &ft.SellUserID,
+		&ft.Quantity, &ft.Price, &ft.FailedStep, &ft.ErrorMessage,
+		&ft.Resolved, &ft.CreatedAt, &ft.ResolvedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to query failed trade: %w", err)
+	}
+	return &ft, nil
+}
+
+// ResolveFailedTrade marks a failure as handled. Returns false if no
+// unresolved row matched.
+func (s *TransactionService) ResolveFailedTrade(ctx context.Context, stockTxID, failedStep string) (bool, error) {
+	query := `
+		UPDATE failed_trades
+		SET resolved = TRUE, resolved_at = CURRENT_TIMESTAMP
+		WHERE stock_tx_id = $1 AND failed_step = $2 AND resolved = FALSE
+	`
+
+	tag, err := s.db.GetDB().Exec(ctx, query, stockTxID, failedStep)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve failed trade: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (s *TransactionService) GetWalletTransactions(ctx context.Context, userID string) ([]models.WalletTransaction, error) {
 	query := `
 		SELECT 
@@ -0,0 +1,320 @@
+package main
+
+// Integration tests against a real Cassandra container. They are opt-in:
+// set RUN_INTEGRATION_TESTS=true (and have a Docker daemon available) to run
+// them; otherwise every test skips and `go test ./...` stays fast.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+var testRouter *gin.Engine
+
+func TestMain(m *testing.M) {
+	if os.Getenv("RUN_INTEGRATION_TESTS") != "true" {
+		// Sessions stay nil; every test skips via requireIntegration.
+		os.Exit(m.Run())
+	}
+
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "cassandra:4.1",
+			ExposedPorts: []string{"9042/tcp"},
+			Env: map[string]string{
+				// The stocks keyspace uses NetworkTopologyStrategy with
+				// datacenter1, so the node must report that DC name.
+				"CASSANDRA_ENDPOINT_SNITCH": "GossipingPropertyFileSnitch",
+				"CASSANDRA_DC":              "datacenter1",
+			},
+			WaitingFor: wait.ForListeningPort("9042/tcp").WithStartupTimeout(3 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		log.Fatal("failed to start Cassandra container:", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		log.Fatal("failed to read container host:", err)
+	}
+	port, err := container.MappedPort(ctx, "9042")
+	if err != nil {
+		log.Fatal("failed to read container port:", err)
+	}
+
+	os.Setenv("CASSANDRA_DB_HOST", host)
+	os.Setenv("CASSANDRA_DB_PORT", port.Port())
+	os.Setenv("CASSANDRA_DB_STOCKS_KEYSPACE", "stocks_keyspace")
+	os.Setenv("CASSANDRA_DB_ORDERS_KEYSPACE", "orders_keyspace")
+
+	// Cassandra accepts TCP connections before CQL is actually ready, so
+	// retry the full connect-and-migrate until it sticks.
+	var initErr error
+	for attempt := 0; attempt < 30; attempt++ {
+		if initErr = initDB(); initErr == nil {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if initErr != nil {
+		_ = container.Terminate(ctx)
+		log.Fatal("failed to initialize Cassandra sessions:", initErr)
+	}
+	initRedis() // publish/rate-limit calls fail open without Redis
+
+	testRouter = setupRouter()
+	code := m.Run()
+
+	if err := container.Terminate(ctx); err != nil {
+		log.Println("failed to terminate Cassandra container:", err)
+	}
+	os.Exit(code)
+}
+
+func requireIntegration(t *testing.T) {
+	t.Helper()
+	if ordersSession == nil {
+		t.Skip("integration tests disabled; set RUN_INTEGRATION_TESTS=true")
+	}
+}
+
+// doRequest performs one request against the service router as the given user.
+func doRequest(t *testing.T, userID int, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var body *bytes.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal("marshalling request payload:", err)
+		}
+		body = bytes.NewReader(raw)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", fmt.Sprintf("%d", userID))
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+func createTestStock(t *testing.T, name string) int {
+	t.Helper()
+	w := doRequest(t, 1, http.MethodPost, "/setup/createStock", map[string]string{"stock_name": name})
+	if w.Code != http.StatusOK {
+		t.Fatalf("createStock returned %d: %s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			StockID int `json:"stock_id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("decoding createStock response:", err)
+	}
+	if !resp.Success || resp.Data.StockID == 0 {
+		t.Fatalf("createStock did not return a stock ID: %s", w.Body.String())
+	}
+	return resp.Data.StockID
+}
+
+func TestCreateStock(t *testing.T) {
+	requireIntegration(t)
+
+	name := fmt.Sprintf("it-create-%d", time.Now().UnixNano())
+	stockID := createTestStock(t, name)
+
+	// Exactly one lookup row must exist for the name, pointing at the new ID.
+	var lookedUp int
+	if err := stocksSession.Query(`
+        SELECT stock_id FROM stocks_keyspace.stock_lookup WHERE stock_name = ?
+    `, name).Scan(&lookedUp); err != nil {
+		t.Fatal("reading stock_lookup:", err)
+	}
+	if lookedUp != stockID {
+		t.Fatalf("stock_lookup has stock_id %d, want %d", lookedUp, stockID)
+	}
+
+	// Creating the same name again must be rejected, not duplicated.
+	w := doRequest(t, 1, http.MethodPost, "/setup/createStock", map[string]string{"stock_name": name})
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("duplicate createStock returned %d, want 400", w.Code)
+	}
+}
+
+func TestAddStockToUserConcurrent(t *testing.T) {
+	requireIntegration(t)
+
+	stockID := createTestStock(t, fmt.Sprintf("it-addqty-%d", time.Now().UnixNano()))
+
+	const (
+		workers = 10
+		perCall = 5
+	)
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doRequest(t, 1, http.MethodPost, "/setup/addStockToUser", map[string]interface{}{
+				"stock_id": fmt.Sprintf("%d", stockID),
+				"quantity": perCall,
+			})
+			if w.Code != http.StatusOK {
+				errs <- fmt.Sprintf("addStockToUser returned %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+
+	var quantity int
+	if err := stocksSession.Query(`
+        SELECT quantity FROM stocks_keyspace.stocks WHERE stock_id = ?
+    `, stockID).Scan(&quantity); err != nil {
+		t.Fatal("reading stock quantity:", err)
+	}
+	if want := workers * perCall; quantity != want {
+		t.Fatalf("concurrent increments lost updates: quantity = %d, want %d", quantity, want)
+	}
+}
+
+// countOrders counts a user's rows in one order table for one stock.
+func countOrders(t *testing.T, table string, userID, stockID int) int {
+	t.Helper()
+	var count int
+	if err := ordersSession.Query(`
+        SELECT COUNT(*) FROM `+table+` WHERE user_id = ? AND stock_id = ?
+    `, userID, stockID).Scan(&count); err != nil {
+		t.Fatalf("counting rows in %s: %v", table, err)
+	}
+	return count
+}
+
+func TestPlaceMarketBuyOrder(t *testing.T) {
+	requireIntegration(t)
+
+	const userID = 42
+	stockID := createTestStock(t, fmt.Sprintf("it-mktbuy-%d", time.Now().UnixNano()))
+
+	w := doRequest(t, userID, http.MethodPost, "/engine/placeStockOrder", map[string]interface{}{
+		"stock_id":   fmt.Sprintf("%d", stockID),
+		"is_buy":     true,
+		"quantity":   3,
+		"order_type": "MARKET",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("placeStockOrder returned %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := countOrders(t, "orders_keyspace.market_buy", userID, stockID); got != 1 {
+		t.Fatalf("market_buy has %d rows for the order, want 1", got)
+	}
+	if got := countOrders(t, "orders_keyspace.market_sell", userID, stockID); got != 0 {
+		t.Fatalf("market_sell has %d rows, want 0", got)
+	}
+
+	var status string
+	var quantity int
+	if err := ordersSession.Query(`
+        SELECT order_status, quantity FROM orders_keyspace.market_buy
+        WHERE user_id = ? AND stock_id = ?
+    `, userID, stockID).Scan(&status, &quantity); err != nil {
+		t.Fatal("reading market_buy row:", err)
+	}
+	if status != "IN_PROGRESS" || quantity != 3 {
+		t.Fatalf("market_buy row has status %q quantity %d, want IN_PROGRESS/3", status, quantity)
+	}
+}
+
+func TestPlaceMarketSellOrder(t *testing.T) {
+	requireIntegration(t)
+
+	const userID = 43
+	stockID := createTestStock(t, fmt.Sprintf("it-mktsell-%d", time.Now().UnixNano()))
+
+	w := doRequest(t, userID, http.MethodPost, "/engine/placeStockOrder", map[string]interface{}{
+		"stock_id":   fmt.Sprintf("%d", stockID),
+		"is_buy":     false,
+		"quantity":   7,
+		"order_type": "MARKET",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("placeStockOrder returned %d: %s", w.Code, w.Body.String())
+	}
+
+	if got := countOrders(t, "orders_keyspace.market_sell", userID, stockID); got != 1 {
+		t.Fatalf("market_sell has %d rows for the order, want 1", got)
+	}
+	if got := countOrders(t, "orders_keyspace.market_buy", userID, stockID); got != 0 {
+		t.Fatalf("market_buy has %d rows, want 0", got)
+	}
+}
+
+func TestCancelStockTransaction(t *testing.T) {
+	requireIntegration(t)
+
+	const userID = 44
+	stockID := createTestStock(t, fmt.Sprintf("it-cancel-%d", time.Now().UnixNano()))
+
+	w := doRequest(t, userID, http.MethodPost, "/engine/placeStockOrder", map[string]interface{}{
+		"stock_id":   fmt.Sprintf("%d", stockID),
+		"is_buy":     true,
+		"quantity":   5,
+		"price":      12.5,
+		"order_type": "LIMIT",
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("placeStockOrder returned %d: %s", w.Code, w.Body.String())
+	}
+
+	var stockTxID string
+	if err := ordersSession.Query(`
+        SELECT stock_tx_id FROM orders_keyspace.limit_buy
+        WHERE user_id = ? AND stock_id = ?
+    `, userID, stockID).Scan(&stockTxID); err != nil {
+		t.Fatal("reading limit_buy row:", err)
+	}
+
+	w = doRequest(t, userID, http.MethodPost, "/engine/cancelStockTransaction", map[string]string{
+		"stock_tx_id": stockTxID,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("cancelStockTransaction returned %d: %s", w.Code, w.Body.String())
+	}
+
+	var status string
+	if err := ordersSession.Query(`
+        SELECT order_status FROM orders_keyspace.limit_buy
+        WHERE user_id = ? AND stock_id = ?
+    `, userID, stockID).Scan(&status); err != nil {
+		t.Fatal("re-reading limit_buy row:", err)
+	}
+	if status != "CANCELLED" {
+		t.Fatalf("order status is %q after cancel, want CANCELLED", status)
+	}
+}
@@ -0,0 +1,149 @@
+// Package cqlparser splits migration files into individual statements.
+//
+// A plain strings.Split on ";" breaks the moment a semicolon appears inside
+// a string literal, a quoted identifier, a comment, or a dollar-quoted
+// function body. This tokenizer walks the source once, tracking quoting and
+// comment state, and records the line each statement starts on so that a
+// failing statement can be reported against the file, not just the file name.
+package cqlparser
+
+import "strings"
+
+// Statement is one executable statement extracted from a migration file.
+type Statement struct {
+	// Text is the statement with surrounding whitespace trimmed and
+	// comments removed.
+	Text string
+	// Line is the 1-based source line the statement's first token appears
+	// on, for error reporting.
+	Line int
+}
+
+// Split tokenizes src into statements. It understands:
+//
+//   - single-quoted string literals, with a doubled quote as the escape
+//   - double-quoted identifiers, with "" as the escape
+//   - dollar-quoted bodies ($$ ... $$ or $tag$ ... $tag$), as used by
+//     PL/pgSQL CREATE FUNCTION
+//   - "--" and "//" line comments and "/* */" block comments
+//
+// A semicolon inside any of those does not terminate a statement. A statement
+// annotated with a "migration:skip" comment is dropped entirely.
+func Split(src string) []Statement {
+	var statements []Statement
+	var cur strings.Builder
+	skip := false
+	line := 1     // current source line
+	stmtLine := 0 // line the current statement started on; 0 = not started
+
+	countLines := func(s string) {
+		line += strings.Count(s, "\n")
+	}
+	write := func(s string) {
+		if stmtLine == 0 && strings.TrimSpace(s) != "" {
+			stmtLine = line
+		}
+		cur.WriteString(s)
+		countLines(s)
+	}
+
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == '\'' || c == '"':
+			// Quoted literal/identifier; a doubled quote is an escape.
+			quote := c
+			start := i
+			i++
+			for i < len(src) {
+				if src[i] == quote {
+					if i+1 < len(src) && src[i+1] == quote {
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				i++
+			}
+			write(src[start:i])
+		case c == '$' && dollarTag(src[i:]) != "":
+			// Dollar-quoted body; everything up to the matching closing tag
+			// is literal, semicolons included.
+			tag := dollarTag(src[i:])
+			start := i
+			i += len(tag)
+			if end := strings.Index(src[i:], tag); end == -1 {
+				i = len(src)
+			} else {
+				i += end + len(tag)
+			}
+			write(src[start:i])
+		case (c == '-' && i+1 < len(src) && src[i+1] == '-') ||
+			(c == '/' && i+1 < len(src) && src[i+1] == '/'):
+			// Line comment, runs to end of line
+			end := strings.IndexByte(src[i:], '\n')
+			var comment string
+			if end == -1 {
+				comment = src[i:]
+				i = len(src)
+			} else {
+				comment = src[i : i+end]
+				i += end
+			}
+			if strings.Contains(comment, "migration:skip") {
+				skip = true
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			// Block comment
+			end := strings.Index(src[i+2:], "*/")
+			var comment string
+			if end == -1 {
+				comment = src[i:]
+				i = len(src)
+			} else {
+				comment = src[i : i+2+end+2]
+				i += 2 + end + 2
+			}
+			if strings.Contains(comment, "migration:skip") {
+				skip = true
+			}
+			countLines(comment)
+		case c == ';':
+			if stmt := strings.TrimSpace(cur.String()); stmt != "" && !skip {
+				statements = append(statements, Statement{Text: stmt, Line: stmtLine})
+			}
+			cur.Reset()
+			stmtLine = 0
+			skip = false
+			i++
+		default:
+			write(src[i : i+1])
+			i++
+		}
+	}
+	if stmt := strings.TrimSpace(cur.String()); stmt != "" && !skip {
+		statements = append(statements, Statement{Text: stmt, Line: stmtLine})
+	}
+	return statements
+}
+
+// dollarTag returns the dollar-quote tag ("$$", "$body$", ...) that s opens
+// with, or "" if s does not start a dollar quote. Tags are a "$", an optional
+// identifier, and a closing "$".
+func dollarTag(s string) string {
+	if len(s) < 2 || s[0] != '$' {
+		return ""
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1]
+		}
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 1 && c >= '0' && c <= '9') {
+			return ""
+		}
+	}
+	return ""
+}
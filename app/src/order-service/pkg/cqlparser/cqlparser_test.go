@@ -0,0 +1,161 @@
+package cqlparser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		name string
+		src  string
+		want []Statement
+	}{
+		{
+			name: "empty input",
+			src:  "",
+			want: nil,
+		},
+		{
+			name: "comments and whitespace only",
+			src:  "-- nothing here\n\n/* or; here */\n",
+			want: nil,
+		},
+		{
+			name: "statement lines skip leading comments and blanks",
+			src:  "-- header comment\n\nCREATE TABLE t (id int);\n\n\nINSERT INTO t VALUES (1);\n",
+			want: []Statement{
+				{Text: "CREATE TABLE t (id int)", Line: 3},
+				{Text: "INSERT INTO t VALUES (1)", Line: 6},
+			},
+		},
+		{
+			name: "trailing statement without semicolon is kept",
+			src:  "SELECT 1;\nSELECT 2",
+			want: []Statement{
+				{Text: "SELECT 1", Line: 1},
+				{Text: "SELECT 2", Line: 2},
+			},
+		},
+		{
+			name: "semicolon inside single-quoted literal",
+			src:  "INSERT INTO t (name) VALUES ('a;b');",
+			want: []Statement{
+				{Text: "INSERT INTO t (name) VALUES ('a;b')", Line: 1},
+			},
+		},
+		{
+			name: "doubled quote escape does not end the literal",
+			src:  "INSERT INTO t (name) VALUES ('it''s; fine');",
+			want: []Statement{
+				{Text: "INSERT INTO t (name) VALUES ('it''s; fine')", Line: 1},
+			},
+		},
+		{
+			name: "semicolon inside double-quoted identifier",
+			src:  `SELECT "weird;name" FROM t;`,
+			want: []Statement{
+				{Text: `SELECT "weird;name" FROM t`, Line: 1},
+			},
+		},
+		{
+			name: "semicolon inside dash line comment",
+			src:  "SELECT 1 -- not a terminator;\n;",
+			want: []Statement{
+				{Text: "SELECT 1", Line: 1},
+			},
+		},
+		{
+			name: "semicolon inside slash line comment",
+			src:  "SELECT 1 // not a terminator;\n;",
+			want: []Statement{
+				{Text: "SELECT 1", Line: 1},
+			},
+		},
+		{
+			name: "semicolon inside block comment and lines counted through it",
+			src:  "/* multi\nline; comment */\nSELECT 2;",
+			want: []Statement{
+				{Text: "SELECT 2", Line: 3},
+			},
+		},
+		{
+			name: "semicolons inside dollar-quoted body",
+			src:  "CREATE FUNCTION f() AS $$ BEGIN x; y; END $$;\nSELECT 3;",
+			want: []Statement{
+				{Text: "CREATE FUNCTION f() AS $$ BEGIN x; y; END $$", Line: 1},
+				{Text: "SELECT 3", Line: 2},
+			},
+		},
+		{
+			name: "tagged dollar quote ignores other dollar quotes inside",
+			src:  "CREATE FUNCTION f() AS $body$\nSELECT '$$'; -- still inside\n$body$;",
+			want: []Statement{
+				{Text: "CREATE FUNCTION f() AS $body$\nSELECT '$$'; -- still inside\n$body$", Line: 1},
+			},
+		},
+		{
+			name: "lone dollar sign is not a quote",
+			src:  "SELECT price, '$' FROM t;",
+			want: []Statement{
+				{Text: "SELECT price, '$' FROM t", Line: 1},
+			},
+		},
+		{
+			name: "unterminated quote swallows the rest of the file",
+			src:  "SELECT 'oops;\nSELECT 2;",
+			want: []Statement{
+				{Text: "SELECT 'oops;\nSELECT 2;", Line: 1},
+			},
+		},
+		{
+			name: "unterminated dollar quote swallows the rest of the file",
+			src:  "CREATE FUNCTION f() AS $$ BEGIN x;\nSELECT 2;",
+			want: []Statement{
+				{Text: "CREATE FUNCTION f() AS $$ BEGIN x;\nSELECT 2;", Line: 1},
+			},
+		},
+		{
+			name: "migration:skip drops only the annotated statement",
+			src:  "-- migration:skip\nDROP TABLE legacy;\nSELECT 1;",
+			want: []Statement{
+				{Text: "SELECT 1", Line: 3},
+			},
+		},
+		{
+			name: "migration:skip in a block comment",
+			src:  "/* migration:skip */ DROP TABLE legacy;\nSELECT 1;",
+			want: []Statement{
+				{Text: "SELECT 1", Line: 2},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Split(tc.src)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Split(%q):\n got %+v\nwant %+v", tc.src, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDollarTag(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{"$$ body $$", "$$"},
+		{"$body$ x $body$", "$body$"},
+		{"$tag_1$ x $tag_1$", "$tag_1$"},
+		{"$1tag$", ""}, // tags cannot start with a digit
+		{"$12.50", ""},
+		{"$", ""},
+		{"plain", ""},
+	}
+	for _, tc := range cases {
+		if got := dollarTag(tc.src); got != tc.want {
+			t.Errorf("dollarTag(%q) = %q, want %q", tc.src, got, tc.want)
+		}
+	}
+}
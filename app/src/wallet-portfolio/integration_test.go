@@ -0,0 +1,258 @@
+package main
+
+// Integration tests against a real CockroachDB container. They are opt-in:
+// set RUN_INTEGRATION_TESTS=true (and have a Docker daemon available) to run
+// them; otherwise every test skips and `go test ./...` stays fast.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+var testRouter *gin.Engine
+
+// nextTestUserID hands out user IDs so tests never share a wallet.
+var nextTestUserID int64 = 10000
+
+func newTestUserID() int {
+	return int(atomic.AddInt64(&nextTestUserID, 1))
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv("RUN_INTEGRATION_TESTS") != "true" {
+		// portfolioDB stays nil; every test skips via requireIntegration.
+		os.Exit(m.Run())
+	}
+
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "cockroachdb/cockroach:v23.1.11",
+			ExposedPorts: []string{"26257/tcp"},
+			Cmd:          []string{"start-single-node", "--insecure"},
+			WaitingFor:   wait.ForListeningPort("26257/tcp").WithStartupTimeout(2 * time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		log.Fatal("failed to start CockroachDB container:", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		log.Fatal("failed to read container host:", err)
+	}
+	port, err := container.MappedPort(ctx, "26257")
+	if err != nil {
+		log.Fatal("failed to read container port:", err)
+	}
+	os.Setenv("COCKROACH_DB_HOST", fmt.Sprintf("%s:%s", host, port.Port()))
+
+	var initErr error
+	for attempt := 0; attempt < 15; attempt++ {
+		if initErr = initDB(); initErr == nil {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+	if initErr != nil {
+		_ = container.Terminate(ctx)
+		log.Fatal("failed to initialize CockroachDB:", initErr)
+	}
+	initRedis() // caching fails open without Redis
+
+	testRouter = setupRouter()
+	code := m.Run()
+
+	if err := container.Terminate(ctx); err != nil {
+		log.Println("failed to terminate CockroachDB container:", err)
+	}
+	os.Exit(code)
+}
+
+func requireIntegration(t *testing.T) {
+	t.Helper()
+	if portfolioDB == nil {
+		t.Skip("integration tests disabled; set RUN_INTEGRATION_TESTS=true")
+	}
+}
+
+// doRequest performs one request against the service router as the given user.
+func doRequest(t *testing.T, userID int, method, path string, payload interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var body *bytes.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal("marshalling request payload:", err)
+		}
+		body = bytes.NewReader(raw)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", fmt.Sprintf("%d", userID))
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+	return w
+}
+
+// walletBalance reads a user's balance straight from the database.
+func walletBalance(t *testing.T, userID int) float64 {
+	t.Helper()
+	var balance float64
+	err := portfolioDB.QueryRow(`
+		SELECT w.balance FROM wallet w WHERE w.user_id = $1
+	`, userID).Scan(&balance)
+	if err != nil {
+		t.Fatal("reading wallet balance:", err)
+	}
+	return balance
+}
+
+func TestAddMoneyCreatesWalletThenAccumulates(t *testing.T) {
+	requireIntegration(t)
+	userID := newTestUserID()
+
+	// First deposit creates the wallet
+	w := doRequest(t, userID, http.MethodPost, "/addMoneyToWallet", map[string]float64{"amount": 50})
+	if w.Code != http.StatusOK {
+		t.Fatalf("first addMoneyToWallet returned %d: %s", w.Code, w.Body.String())
+	}
+	if got := walletBalance(t, userID); got != 50 {
+		t.Fatalf("balance after first deposit = %v, want 50", got)
+	}
+
+	// Second deposit adds to the same wallet rather than creating another
+	w = doRequest(t, userID, http.MethodPost, "/addMoneyToWallet", map[string]float64{"amount": 25})
+	if w.Code != http.StatusOK {
+		t.Fatalf("second addMoneyToWallet returned %d: %s", w.Code, w.Body.String())
+	}
+	if got := walletBalance(t, userID); got != 75 {
+		t.Fatalf("balance after second deposit = %v, want 75", got)
+	}
+
+	var wallets int
+	if err := portfolioDB.QueryRow(`SELECT count(*) FROM wallet WHERE user_id = $1`, userID).Scan(&wallets); err != nil {
+		t.Fatal("counting wallets:", err)
+	}
+	if wallets != 1 {
+		t.Fatalf("user has %d wallet rows, want 1", wallets)
+	}
+}
+
+func TestAddMoneyConcurrent(t *testing.T) {
+	requireIntegration(t)
+	userID := newTestUserID()
+
+	const workers = 10
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doRequest(t, userID, http.MethodPost, "/addMoneyToWallet", map[string]float64{"amount": 100})
+			if w.Code != http.StatusOK {
+				errs <- fmt.Sprintf("addMoneyToWallet returned %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+
+	if got := walletBalance(t, userID); got != 1000 {
+		t.Fatalf("concurrent deposits lost updates: balance = %v, want 1000", got)
+	}
+}
+
+func TestGetStockPortfolioEmptyForNewUser(t *testing.T) {
+	requireIntegration(t)
+	userID := newTestUserID()
+
+	w := doRequest(t, userID, http.MethodGet, "/getStockPortfolio", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("getStockPortfolio returned %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Success bool              `json:"success"`
+		Data    []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal("decoding portfolio response:", err)
+	}
+	if !resp.Success {
+		t.Fatalf("getStockPortfolio reported failure: %s", w.Body.String())
+	}
+	// The data field must be an empty array, not null
+	if resp.Data == nil || len(resp.Data) != 0 {
+		t.Fatalf("portfolio for new user = %s, want []", w.Body.String())
+	}
+}
+
+func TestUpdateStockPortfolioConcurrent(t *testing.T) {
+	requireIntegration(t)
+	userID := newTestUserID()
+
+	const (
+		workers = 10
+		stockID = 77
+		perCall = 4
+	)
+	var wg sync.WaitGroup
+	errs := make(chan string, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := doRequest(t, 0, http.MethodPost, "/internal/updateStockPortfolio", map[string]int{
+				"user_id":  userID,
+				"stock_id": stockID,
+				"quantity": perCall,
+			})
+			if w.Code != http.StatusOK {
+				errs <- fmt.Sprintf("updateStockPortfolio returned %d: %s", w.Code, w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for msg := range errs {
+		t.Error(msg)
+	}
+
+	var quantity int
+	err := portfolioDB.QueryRow(`
+		SELECT sp.quantity_owned
+		FROM stock_portfolio sp
+		JOIN wallet w ON w.wallet_id = sp.wallet_id
+		WHERE w.user_id = $1 AND sp.stock_id = $2
+	`, userID, stockID).Scan(&quantity)
+	if err != nil {
+		t.Fatal("reading portfolio quantity:", err)
+	}
+	if want := workers * perCall; quantity != want {
+		t.Fatalf("concurrent portfolio updates lost increments: quantity = %d, want %d", quantity, want)
+	}
+}
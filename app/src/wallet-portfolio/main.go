@@ -1,19 +1,26 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
+	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"golang.org/x/sync/errgroup"
 )
 
 // -----------------------------------------------------------------------------
@@ -60,34 +67,134 @@ type Response struct {
 // Globals & DB Setup
 // -----------------------------------------------------------------------------
 
-var portfolioDB *sql.DB
+var (
+	portfolioDB *sql.DB
+	dbPool      *DBPool
+	redisClient *redis.Client
+)
+
+// DBPool pairs the primary CockroachDB connection with an optional read-only
+// replica. The read-heavy GET handlers (balance, portfolio, search) tolerate
+// slightly stale data, so they can be pointed at a replica via
+// DB_READ_REPLICA_URL while every mutating path stays on the primary.
+type DBPool struct {
+	primary *sql.DB
+	replica *sql.DB
+}
+
+// reads returns the connection read-only handlers should query: the replica
+// when one is configured and answering pings, otherwise the primary. The
+// per-call ping means a replica outage degrades to primary reads instead of
+// failing requests.
+func (p *DBPool) reads(ctx context.Context) *sql.DB {
+	if p.replica == nil {
+		return p.primary
+	}
+	if err := p.replica.PingContext(ctx); err != nil {
+		log.Println("Warning: read replica unreachable, falling back to primary:", err)
+		return p.primary
+	}
+	return p.replica
+}
+
+// sandboxMode reports whether the service runs isolated from production
+// data: a separate sandbox database, Redis DB 1, and gin's test mode.
+func sandboxMode() bool {
+	return os.Getenv("SANDBOX_MODE") == "true"
+}
+
+// initRedis connects the Redis client used for short-lived response caching.
+func initRedis() {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "redis:6379"
+	}
+	opts := &redis.Options{Addr: addr}
+	if sandboxMode() {
+		opts.DB = 1
+	}
+	redisClient = redis.NewClient(opts)
+	if err := redisClient.Ping(context.Background()).Err(); err != nil {
+		log.Println("Warning: could not reach Redis at", addr, "-", err)
+	}
+}
 
 func initDB() error {
-	dsn := "postgresql://root@cockroach-db:26257/?sslmode=disable"
+	// host:port of the CockroachDB node; overridable so tests can point the
+	// service at a container with a mapped port.
+	host := os.Getenv("COCKROACH_DB_HOST")
+	if host == "" {
+		host = "cockroach-db:26257"
+	}
+
+	dbName := "portfolio-db"
+	if sandboxMode() {
+		dbName = "sandbox-portfolio-db"
+	}
+
+	dsn := fmt.Sprintf("postgresql://root@%s/?sslmode=disable", host)
 	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return fmt.Errorf("error opening DB: %v", err)
 	}
 	defer db.Close()
 
-	_, err = db.Exec(`CREATE DATABASE IF NOT EXISTS "portfolio-db";`)
+	_, err = db.Exec(fmt.Sprintf(`CREATE DATABASE IF NOT EXISTS "%s";`, dbName))
 	if err != nil {
-		return fmt.Errorf("error creating 'portfolio-db': %v", err)
+		return fmt.Errorf("error creating '%s': %v", dbName, err)
 	}
 
-	dsn = "postgresql://root@cockroach-db:26257/portfolio-db?sslmode=disable"
+	dsn = fmt.Sprintf("postgresql://root@%s/%s?sslmode=disable", host, dbName)
 	portfolioDB, err = sql.Open("postgres", dsn)
 	if err != nil {
-		return fmt.Errorf("error connecting to 'portfolio-db': %v", err)
+		return fmt.Errorf("error connecting to '%s': %v", dbName, err)
 	}
 	if err = portfolioDB.Ping(); err != nil {
 		portfolioDB.Close()
-		return fmt.Errorf("error pinging 'portfolio-db': %v", err)
+		return fmt.Errorf("error pinging '%s': %v", dbName, err)
+	}
+
+	dbPool = &DBPool{primary: portfolioDB}
+	if replicaURL := os.Getenv("DB_READ_REPLICA_URL"); replicaURL != "" {
+		replica, err := sql.Open("postgres", replicaURL)
+		if err != nil {
+			return fmt.Errorf("error opening read replica: %v", err)
+		}
+		if err := replica.Ping(); err != nil {
+			// Not fatal: reads() falls back to the primary until it recovers.
+			log.Println("Warning: read replica unreachable at startup:", err)
+		} else {
+			log.Println("✅ Read replica connected; GET handlers will prefer it")
+		}
+		dbPool.replica = replica
 	}
 
 	return applyMigrations(portfolioDB)
 }
 
+// sandboxResetHandler truncates every table in the sandbox database so a CI
+// suite starts from a clean slate. The route is only registered in sandbox
+// mode; the in-handler guard is belt and braces.
+func sandboxResetHandler(c *gin.Context) {
+	if !sandboxMode() {
+		c.JSON(http.StatusNotFound, Response{Success: false, Message: "Only available in sandbox mode"})
+		return
+	}
+
+	tables := []string{"wallet_transactions", "stock_portfolio", "wallet", "stocks"}
+	for _, table := range tables {
+		if _, err := portfolioDB.ExecContext(c, "TRUNCATE TABLE "+table+" CASCADE"); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
+				Success: false, Message: fmt.Sprintf("Error truncating %s: %v", table, err),
+			})
+			return
+		}
+	}
+
+	log.Println("✅ Sandbox reset: truncated", len(tables), "tables")
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"tables_truncated": len(tables)}})
+}
+
 func applyMigrations(db *sql.DB) error {
 	content, err := os.ReadFile("migrations/portfolio_table.sql")
 	if err != nil {
@@ -100,17 +207,149 @@ func applyMigrations(db *sql.DB) error {
 	return nil
 }
 
-func init() {
+// logDBStatsLoop logs the connection pool statistics every 30 seconds. When
+// load spikes surface as "context deadline exceeded", these lines show
+// whether the pool was the bottleneck (WaitCount/WaitDuration climbing) or
+// the database itself was slow.
+func logDBStatsLoop() {
+	for range time.Tick(30 * time.Second) {
+		logPoolStats("primary", portfolioDB)
+		if dbPool.replica != nil {
+			logPoolStats("replica", dbPool.replica)
+		}
+	}
+}
+
+func logPoolStats(pool string, db *sql.DB) {
+	s := db.Stats()
+	log.Printf("db pool %s: max_open=%d open=%d in_use=%d idle=%d wait_count=%d wait_duration=%s max_idle_closed=%d max_lifetime_closed=%d",
+		pool, s.MaxOpenConnections, s.OpenConnections, s.InUse, s.Idle,
+		s.WaitCount, s.WaitDuration, s.MaxIdleClosed, s.MaxLifetimeClosed)
+}
+
+// metricsHandler serves the same pool statistics in the Prometheus text
+// exposition format. Eight numbers do not justify a client library, so the
+// output is written by hand; gauges for the point-in-time values, counters
+// for the cumulative ones.
+func metricsHandler(c *gin.Context) {
+	var b strings.Builder
+	metric := func(name, metricType, help string, value func(sql.DBStats) float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+		fmt.Fprintf(&b, "%s{pool=\"primary\"} %g\n", name, value(portfolioDB.Stats()))
+		if dbPool.replica != nil {
+			fmt.Fprintf(&b, "%s{pool=\"replica\"} %g\n", name, value(dbPool.replica.Stats()))
+		}
+	}
+	metric("wallet_db_max_open_connections", "gauge", "Pool size limit.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	metric("wallet_db_open_connections", "gauge", "Connections currently established.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	metric("wallet_db_in_use_connections", "gauge", "Connections currently executing queries.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	metric("wallet_db_idle_connections", "gauge", "Established connections sitting idle.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	metric("wallet_db_wait_count_total", "counter", "Times a query had to wait for a connection.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	metric("wallet_db_wait_duration_seconds_total", "counter", "Total time spent waiting for a connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	metric("wallet_db_max_idle_closed_total", "counter", "Connections closed for exceeding MaxIdleConns.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	metric("wallet_db_max_lifetime_closed_total", "counter", "Connections closed for exceeding ConnMaxLifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// bootstrap connects to CockroachDB and Redis and applies migrations. Called
+// from main rather than init so tests can start their own database first.
+func bootstrap() {
 	_ = godotenv.Load()
 	if err := initDB(); err != nil {
 		log.Fatalf("Could not init DB: %v", err)
 	}
+	initRedis()
 }
 
 // -----------------------------------------------------------------------------
 // Helpers
 // -----------------------------------------------------------------------------
 
+// isRetryableDBError reports whether an error is a transient CockroachDB
+// failure worth retrying: a serialization failure (SQLSTATE 40001) or a
+// unique constraint violation (23505) from concurrent upserts.
+func isRetryableDBError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "23505"
+	}
+	return false
+}
+
+// withRetry runs fn up to maxAttempts times, retrying only transient database
+// errors. The backoff doubles per attempt with jitter so concurrent retries
+// do not stampede the database in lockstep.
+func withRetry(ctx context.Context, maxAttempts int, fn func() error) error {
+	backoff := 50 * time.Millisecond
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = fn(); err == nil || !isRetryableDBError(err) {
+			return err
+		}
+		if attempt == maxAttempts {
+			break
+		}
+		sleep := time.Duration(float64(backoff) * (0.5 + rand.Float64()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sleep):
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// isSerializationFailure reports SQLSTATE 40001 specifically - the only error
+// class the savepoint retry protocol below is allowed to retry.
+func isSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40001"
+}
+
+// runInRetryableTransaction executes fn inside one transaction using
+// CockroachDB's recommended client-side retry protocol: SAVEPOINT
+// cockroach_restart right after BEGIN, and on a serialization failure the
+// transaction rolls back to the savepoint and fn runs again from scratch
+// inside the same transaction. Unlike withRetry (which opens a fresh
+// transaction per attempt), this keeps the transaction's internal priority,
+// which CockroachDB raises on every restart so a contended transaction
+// eventually wins instead of starving.
+func runInRetryableTransaction(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT cockroach_restart"); err != nil {
+		return err
+	}
+	for {
+		err = fn(tx)
+		if err == nil {
+			// RELEASE commits the work and can itself fail with 40001
+			if _, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT cockroach_restart"); err == nil {
+				return tx.Commit()
+			}
+		}
+		if !isSerializationFailure(err) {
+			return err
+		}
+		if _, rbErr := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT cockroach_restart"); rbErr != nil {
+			return rbErr
+		}
+	}
+}
+
 func checkAuthorization(c *gin.Context) int {
 	userIDStr := c.GetHeader("X-User-ID")
 	if userIDStr == "" {
@@ -135,19 +374,19 @@ func checkAuthorization(c *gin.Context) int {
 	return userID
 }
 
-func createWalletIfNotExists(userID int) (string, error) {
+func createWalletIfNotExists(ctx context.Context, userID int) (string, error) {
 	var walletID string
-	err := portfolioDB.QueryRow(`SELECT wallet_id FROM wallet WHERE user_id=$1`, userID).Scan(&walletID)
-	if err == sql.ErrNoRows {
-		walletID = uuid.NewString()
-		_, err = portfolioDB.Exec(`
-			INSERT INTO wallet (wallet_id, user_id, balance) VALUES ($1, $2, 0)
-		`, walletID, userID)
-		if err != nil {
-			return "", err
+	err := withRetry(ctx, 3, func() error {
+		err := portfolioDB.QueryRowContext(ctx, `SELECT wallet_id FROM wallet WHERE user_id=$1`, userID).Scan(&walletID)
+		if err == sql.ErrNoRows {
+			walletID = uuid.NewString()
+			_, err = portfolioDB.ExecContext(ctx, `
+				INSERT INTO wallet (wallet_id, user_id, balance) VALUES ($1, $2, 0)
+			`, walletID, userID)
 		}
-		return walletID, nil
-	} else if err != nil {
+		return err
+	})
+	if err != nil {
 		return "", err
 	}
 	return walletID, nil
@@ -157,6 +396,15 @@ func createWalletIfNotExists(userID int) (string, error) {
 // Handlers
 // -----------------------------------------------------------------------------
 
+// bindJSONStrict decodes the request body into dst, rejecting unknown fields
+// so a misspelled field name fails loudly instead of silently binding a zero.
+// The returned error names the offending field.
+func bindJSONStrict(c *gin.Context, dst interface{}) error {
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(dst)
+}
+
 func addMoneyHandler(c *gin.Context) {
 	userID := checkAuthorization(c)
 	if userID == -1 {
@@ -165,8 +413,8 @@ func addMoneyHandler(c *gin.Context) {
 	var req struct {
 		Amount float64 `json:"amount"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+	if err := bindJSONStrict(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body: " + err.Error()})
 		return
 	}
 	if req.Amount <= 0 {
@@ -174,46 +422,197 @@ func addMoneyHandler(c *gin.Context) {
 		return
 	}
 
-	walletID, err := createWalletIfNotExists(userID)
+	walletID, err := createWalletIfNotExists(c, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
 		return
 	}
 
-	tx, err := portfolioDB.BeginTx(c, nil)
+	// The whole credit transaction re-runs from the cockroach_restart
+	// savepoint on serialization failures instead of being abandoned.
+	err = runInRetryableTransaction(c, portfolioDB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(c,
+			`UPDATE wallet
+	         SET balance = balance + $1,
+	             updated_at = current_timestamp
+	         WHERE wallet_id = $2`,
+			req.Amount, walletID)
+		if err != nil {
+			return err
+		}
+
+		walletTxID := uuid.NewString()
+		_, err = tx.ExecContext(c,
+			`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, tx_type, amount)
+	         VALUES ($1, $2, false, 'DEPOSIT', $3)`,
+			walletTxID, walletID, req.Amount)
+		return err
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "DB transaction error"})
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to add money: " + err.Error()})
 		return
 	}
-	defer tx.Rollback()
 
-	_, err = tx.ExecContext(c,
-		`UPDATE wallet
-         SET balance = balance + $1,
-             updated_at = current_timestamp
-         WHERE wallet_id = $2`,
-		req.Amount, walletID)
+	dropUserCache(c.Request.Context(), fmt.Sprintf("wallet_balance:%d", userID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// callUserExists asks the auth service whether a user ID is real, so money
+// cannot be transferred into a wallet nobody can ever log into.
+func callUserExists(ctx context.Context, userID int) (bool, error) {
+	url := fmt.Sprintf("%s/internal/userExists?user_id=%d",
+		serviceURL("AUTH_SERVICE_URL", "http://auth-service:8080"), userID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	if secret := os.Getenv("INTERNAL_API_SECRET"); secret != "" {
+		req.Header.Set("X-Internal-Secret", secret)
+	}
+	resp, err := priceClient.Do(req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to update balance"})
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("auth service returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Exists bool `json:"exists"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return false, err
+	}
+	return body.Exists, nil
+}
+
+// transferBetweenAccountsHandler moves money from the caller's wallet to
+// another user's. The debit, the credit and the two wallet_transactions rows
+// (linked by a shared transfer_id) happen in one transaction, so a transfer
+// either fully happens or fully does not.
+func transferBetweenAccountsHandler(c *gin.Context) {
+	senderID := checkAuthorization(c)
+	if senderID == -1 {
+		return
+	}
+	var req struct {
+		RecipientUserID int     `json:"recipient_user_id"`
+		Amount          float64 `json:"amount"`
+		Memo            string  `json:"memo"`
+	}
+	if err := bindJSONStrict(c, &req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body: " + err.Error()})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Amount must be > 0"})
+		return
+	}
+	if req.RecipientUserID == senderID {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Cannot transfer to yourself"})
+		return
+	}
+	if len(req.Memo) > 255 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Memo must be at most 255 characters"})
 		return
 	}
 
-	walletTxID := uuid.NewString()
-	_, err = tx.ExecContext(c,
-		`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, amount)
-         VALUES ($1, $2, false, $3)`,
-		walletTxID, walletID, req.Amount)
+	exists, err := callUserExists(c.Request.Context(), req.RecipientUserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to log transaction"})
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Could not verify recipient: " + err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Recipient does not exist"})
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to commit transaction"})
+	senderWallet, err := createWalletIfNotExists(c, senderID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
+		return
+	}
+	recipientWallet, err := createWalletIfNotExists(c, req.RecipientUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch recipient wallet"})
 		return
 	}
 
-	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+	transferID := uuid.NewString()
+	err = runInRetryableTransaction(c, portfolioDB, func(tx *sql.Tx) error {
+		// Conditional debit: no matching row means insufficient balance.
+		var newBalance float64
+		err := tx.QueryRowContext(c,
+			`UPDATE wallet
+			 SET balance = balance - $1, updated_at = current_timestamp
+			 WHERE wallet_id = $2 AND balance >= $1
+			 RETURNING balance`, req.Amount, senderWallet).Scan(&newBalance)
+		if err == sql.ErrNoRows {
+			return errInsufficientFunds
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(c,
+			`UPDATE wallet
+			 SET balance = balance + $1, updated_at = current_timestamp
+			 WHERE wallet_id = $2`, req.Amount, recipientWallet); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(c,
+			`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, tx_type, amount, transfer_id, memo)
+			 VALUES ($1, $2, true, 'TRANSFER', $3, $4, $5)`,
+			uuid.NewString(), senderWallet, req.Amount, transferID, req.Memo); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(c,
+			`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, tx_type, amount, transfer_id, memo)
+			 VALUES ($1, $2, false, 'TRANSFER', $3, $4, $5)`,
+			uuid.NewString(), recipientWallet, req.Amount, transferID, req.Memo)
+		return err
+	})
+	if err == errInsufficientFunds {
+		c.JSON(http.StatusPaymentRequired, Response{Success: false, Message: "Insufficient funds"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to transfer: " + err.Error()})
+		return
+	}
+
+	dropUserCache(c.Request.Context(),
+		fmt.Sprintf("wallet_balance:%d", senderID),
+		fmt.Sprintf("wallet_balance:%d", req.RecipientUserID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"transfer_id": transferID}})
+}
+
+// -----------------------------------------------------------------------------
+// Per-user read caches
+//
+// getWalletBalance and getStockPortfolio hit CockroachDB on every call; both
+// are cached in Redis per user with short TTLs so hot dashboards stop hammering
+// the database. Writes drop the affected keys, and the TTL bounds how long a
+// missed invalidation can serve stale data.
+// -----------------------------------------------------------------------------
+
+const walletBalanceCacheTTL = 5 * time.Second
+
+// portfolioCacheTTL reads PORTFOLIO_CACHE_TTL_SECONDS, defaulting to 10.
+func portfolioCacheTTL() time.Duration {
+	if v := os.Getenv("PORTFOLIO_CACHE_TTL_SECONDS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return 10 * time.Second
+}
+
+// dropUserCache invalidates cached reads after a write. Failures are logged
+// only; the TTL limits how long the stale entry survives.
+func dropUserCache(ctx context.Context, keys ...string) {
+	if err := redisClient.Del(ctx, keys...).Err(); err != nil {
+		log.Println("Warning: could not invalidate cache:", err)
+	}
 }
 
 func getWalletBalanceHandler(c *gin.Context) {
@@ -222,14 +621,20 @@ func getWalletBalanceHandler(c *gin.Context) {
 		return
 	}
 
-	walletID, err := createWalletIfNotExists(userID)
+	cacheKey := fmt.Sprintf("wallet_balance:%d", userID)
+	if raw, err := redisClient.Get(c.Request.Context(), cacheKey).Result(); err == nil {
+		c.JSON(http.StatusOK, Response{Success: true, Data: json.RawMessage(raw)})
+		return
+	}
+
+	walletID, err := createWalletIfNotExists(c, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
 		return
 	}
 
 	var balance float64
-	err = portfolioDB.QueryRowContext(c,
+	err = dbPool.reads(c).QueryRowContext(c,
 		`SELECT balance FROM wallet WHERE wallet_id=$1`, walletID).Scan(&balance)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
@@ -241,7 +646,13 @@ func getWalletBalanceHandler(c *gin.Context) {
 	type Bal struct {
 		Balance float64 `json:"balance"`
 	}
-	c.JSON(http.StatusOK, Response{Success: true, Data: Bal{Balance: balance}})
+	bal := Bal{Balance: balance}
+	if payload, err := json.Marshal(bal); err == nil {
+		if err := redisClient.Set(context.Background(), cacheKey, payload, walletBalanceCacheTTL).Err(); err != nil {
+			log.Println("Warning: could not cache wallet balance:", err)
+		}
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: bal})
 }
 
 func getWalletTransactionsHandler(c *gin.Context) {
@@ -250,7 +661,7 @@ func getWalletTransactionsHandler(c *gin.Context) {
 		return
 	}
 
-	walletID, err := createWalletIfNotExists(userID)
+	walletID, err := createWalletIfNotExists(c, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false, Message: "Failed to create/fetch wallet",
@@ -258,7 +669,7 @@ func getWalletTransactionsHandler(c *gin.Context) {
 		return
 	}
 
-	rows, err := portfolioDB.QueryContext(c,
+	rows, err := dbPool.reads(c).QueryContext(c,
 		`SELECT wallet_tx_id, stock_tx_id, is_debit, amount, updated_at
          FROM wallet_transactions
          WHERE wallet_id=$1
@@ -298,7 +709,13 @@ func getStockPortfolioHandler(c *gin.Context) {
 		return
 	}
 
-	walletID, err := createWalletIfNotExists(userID)
+	cacheKey := fmt.Sprintf("portfolio:%d", userID)
+	if raw, err := redisClient.Get(c.Request.Context(), cacheKey).Result(); err == nil {
+		c.JSON(http.StatusOK, Response{Success: true, Data: json.RawMessage(raw)})
+		return
+	}
+
+	walletID, err := createWalletIfNotExists(c, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false, Message: "Failed to create/fetch wallet",
@@ -306,7 +723,7 @@ func getStockPortfolioHandler(c *gin.Context) {
 		return
 	}
 
-	rows, err := portfolioDB.QueryContext(c,
+	rows, err := dbPool.reads(c).QueryContext(c,
 		`SELECT stock_id, quantity_owned, updated_at
 		 FROM stock_portfolio
 		 WHERE wallet_id=$1
@@ -319,7 +736,8 @@ func getStockPortfolioHandler(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var items []StockPortfolioItem
+	// A user with no holdings gets an empty array, not null
+	items := []StockPortfolioItem{}
 	for rows.Next() {
 		var spi StockPortfolioItem
 		if scanErr := rows.Scan(&spi.StockID, &spi.QuantityOwned, &spi.UpdatedAt); scanErr != nil {
@@ -330,18 +748,794 @@ func getStockPortfolioHandler(c *gin.Context) {
 		}
 		items = append(items, spi)
 	}
+	if payload, err := json.Marshal(items); err == nil {
+		if err := redisClient.Set(context.Background(), cacheKey, payload, portfolioCacheTTL()).Err(); err != nil {
+			log.Println("Warning: could not cache portfolio:", err)
+		}
+	}
 	c.JSON(http.StatusOK, Response{Success: true, Data: items})
 }
 
-func main() {
-	gin.SetMode(gin.ReleaseMode)
+// updateStockPortfolioHandler adjusts a user's holding of a stock by a signed
+// delta. Used internally by order-service (e.g. gifting, trade settlement).
+// A negative delta that would take the holding below zero is rejected.
+func updateStockPortfolioHandler(c *gin.Context) {
+	var req struct {
+		UserID   int `json:"user_id"`
+		StockID  int `json:"stock_id"`
+		Quantity int `json:"quantity"` // signed delta
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.Quantity == 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Quantity delta must be non-zero"})
+		return
+	}
+
+	walletID, err := createWalletIfNotExists(c, req.UserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
+		return
+	}
+
+	// The read-check-write transaction is retried on serialization failures
+	// so concurrent deltas against the same holding do not surface as errors.
+	err = withRetry(c, 3, func() error {
+		tx, err := portfolioDB.BeginTx(c, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var currentQty int
+		err = tx.QueryRowContext(c,
+			`SELECT quantity_owned FROM stock_portfolio WHERE wallet_id=$1 AND stock_id=$2`,
+			walletID, req.StockID).Scan(&currentQty)
+		if err == sql.ErrNoRows {
+			if req.Quantity < 0 {
+				return errInsufficientStock
+			}
+			_, err = tx.ExecContext(c,
+				`INSERT INTO stock_portfolio (wallet_id, stock_id, quantity_owned) VALUES ($1, $2, $3)`,
+				walletID, req.StockID, req.Quantity)
+		} else if err == nil {
+			if currentQty+req.Quantity < 0 {
+				return errInsufficientStock
+			}
+			_, err = tx.ExecContext(c,
+				`UPDATE stock_portfolio
+				 SET quantity_owned = quantity_owned + $1, updated_at = current_timestamp
+				 WHERE wallet_id=$2 AND stock_id=$3`,
+				req.Quantity, walletID, req.StockID)
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err == errInsufficientStock {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Insufficient stock quantity"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to update portfolio"})
+		return
+	}
+	dropUserCache(c.Request.Context(), fmt.Sprintf("portfolio:%d", req.UserID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// -----------------------------------------------------------------------------
+// Net worth
+// -----------------------------------------------------------------------------
+
+// NetWorthPosition is one holding valued at the current market quote. The
+// portfolio schema stores no cost basis, so unrealized gain/loss is measured
+// against today's opening price (intraday P/L).
+type NetWorthPosition struct {
+	StockID            int     `json:"stock_id"`
+	QuantityOwned      int     `json:"quantity_owned"`
+	CurrentPrice       float64 `json:"current_price"`
+	MarketValue        float64 `json:"market_value"`
+	OpenPrice          float64 `json:"open_price"`
+	UnrealizedGainLoss float64 `json:"unrealized_gain_loss"`
+}
+
+type NetWorthSummary struct {
+	WalletBalance  float64            `json:"wallet_balance"`
+	PortfolioValue float64            `json:"portfolio_value"`
+	TotalNetWorth  float64            `json:"total_net_worth"`
+	Positions      []NetWorthPosition `json:"positions"`
+}
+
+var priceClient = &http.Client{Timeout: 5 * time.Second}
+
+func serviceURL(envKey, fallback string) string {
+	if url := os.Getenv(envKey); url != "" {
+		return url
+	}
+	return fallback
+}
+
+// priceGet issues a GET tied to the caller's context, so a disconnecting
+// client cancels the outbound call instead of leaving it to run until the
+// client timeout.
+func priceGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return priceClient.Do(req)
+}
+
+// fetchCurrentPrice asks the matching service for the best quote of a stock.
+// The mid price is used when both sides exist; with a one-sided book the
+// available side is used. An empty book prices the stock at zero.
+func fetchCurrentPrice(ctx context.Context, stockID int) (float64, error) {
+	url := fmt.Sprintf("%s/bestQuote/%d",
+		serviceURL("MATCHING_SERVICE_URL", "http://matching-service:8084"), stockID)
+	resp, err := priceGet(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("matching service returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data struct {
+			BestBid *float64 `json:"best_bid"`
+			BestAsk *float64 `json:"best_ask"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	switch {
+	case body.Data.BestBid != nil && body.Data.BestAsk != nil:
+		return (*body.Data.BestBid + *body.Data.BestAsk) / 2, nil
+	case body.Data.BestBid != nil:
+		return *body.Data.BestBid, nil
+	case body.Data.BestAsk != nil:
+		return *body.Data.BestAsk, nil
+	default:
+		return 0, nil
+	}
+}
+
+// fetchOpenPrice reads today's opening price from order-history, or zero if
+// the stock has not traded today.
+func fetchOpenPrice(ctx context.Context, stockID int) (float64, error) {
+	today := time.Now().Format("2006-01-02")
+	url := fmt.Sprintf("%s/getMarketData?stock_id=%d&from=%s&to=%s",
+		serviceURL("ORDER_HISTORY_SERVICE_URL", "http://order-history-service:8082"),
+		stockID, today, today)
+	resp, err := priceGet(ctx, url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("order-history service returned status %d", resp.StatusCode)
+	}
+	var body struct {
+		Data []struct {
+			OpenPrice float64 `json:"open_price"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, err
+	}
+	if len(body.Data) == 0 {
+		return 0, nil
+	}
+	return body.Data[0].OpenPrice, nil
+}
+
+const netWorthCacheTTL = 10 * time.Second
+
+// getNetWorthHandler combines the wallet balance with the market value of all
+// holdings. Balance and portfolio are read concurrently, then every holding
+// is priced concurrently via the matching service. Results are cached in
+// Redis for a few seconds per user.
+func getNetWorthHandler(c *gin.Context) {
+	userID := checkAuthorization(c)
+	if userID == -1 {
+		return
+	}
+
+	cacheKey := fmt.Sprintf("net_worth:%d", userID)
+	if raw, err := redisClient.Get(c.Request.Context(), cacheKey).Result(); err == nil {
+		c.JSON(http.StatusOK, Response{Success: true, Data: json.RawMessage(raw)})
+		return
+	}
+
+	walletID, err := createWalletIfNotExists(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var (
+		balance float64
+		items   []StockPortfolioItem
+	)
+	// Resolve the read connection once so both goroutines share one ping.
+	readDB := dbPool.reads(ctx)
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return readDB.QueryRowContext(gctx,
+			`SELECT balance FROM wallet WHERE wallet_id=$1`, walletID).Scan(&balance)
+	})
+	g.Go(func() error {
+		rows, err := readDB.QueryContext(gctx,
+			`SELECT stock_id, quantity_owned, updated_at
+			 FROM stock_portfolio
+			 WHERE wallet_id=$1 AND quantity_owned > 0
+			 ORDER BY stock_id ASC`, walletID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var spi StockPortfolioItem
+			if err := rows.Scan(&spi.StockID, &spi.QuantityOwned, &spi.UpdatedAt); err != nil {
+				return err
+			}
+			items = append(items, spi)
+		}
+		return rows.Err()
+	})
+	if err := g.Wait(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to read wallet/portfolio: " + err.Error()})
+		return
+	}
+
+	positions := make([]NetWorthPosition, len(items))
+	pg, pctx := errgroup.WithContext(ctx)
+	for i, item := range items {
+		i, item := i, item
+		pg.Go(func() error {
+			price, err := fetchCurrentPrice(pctx, item.StockID)
+			if err != nil {
+				return err
+			}
+			open, err := fetchOpenPrice(pctx, item.StockID)
+			if err != nil {
+				return err
+			}
+			positions[i] = NetWorthPosition{
+				StockID:            item.StockID,
+				QuantityOwned:      item.QuantityOwned,
+				CurrentPrice:       price,
+				MarketValue:        price * float64(item.QuantityOwned),
+				OpenPrice:          open,
+				UnrealizedGainLoss: (price - open) * float64(item.QuantityOwned),
+			}
+			return nil
+		})
+	}
+	if err := pg.Wait(); err != nil {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Failed to price portfolio: " + err.Error()})
+		return
+	}
+
+	summary := NetWorthSummary{
+		WalletBalance: balance,
+		Positions:     positions,
+	}
+	for _, p := range positions {
+		summary.PortfolioValue += p.MarketValue
+	}
+	summary.TotalNetWorth = summary.WalletBalance + summary.PortfolioValue
+
+	if payload, err := json.Marshal(summary); err == nil {
+		if err := redisClient.Set(context.Background(), cacheKey, payload, netWorthCacheTTL).Err(); err != nil {
+			log.Println("Warning: could not cache net worth:", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, Response{Success: true, Data: summary})
+}
+
+// updateStockNameHandler upserts a stock's display name, called by
+// order-service when a company renames a stock so both stores stay aligned.
+func updateStockNameHandler(c *gin.Context) {
+	var req struct {
+		StockID   int    `json:"stock_id"`
+		StockName string `json:"stock_name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.StockID <= 0 || req.StockName == "" {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "stock_id and stock_name are required"})
+		return
+	}
+
+	_, err := portfolioDB.ExecContext(c,
+		`INSERT INTO stocks (stock_id, stock_name)
+		 VALUES ($1, $2)
+		 ON CONFLICT (stock_id) DO UPDATE
+		 SET stock_name = EXCLUDED.stock_name, updated_at = current_timestamp`,
+		req.StockID, req.StockName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to update stock name"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// searchStocksHandler finds stocks by name: full-text match (backed by the
+// inverted index on to_tsvector) for whole words, ILIKE for substrings.
+func searchStocksHandler(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "q is required"})
+		return
+	}
+
+	rows, err := dbPool.reads(c).QueryContext(c,
+		`SELECT stock_id, stock_name
+		 FROM stocks
+		 WHERE stock_name ILIKE '%' || $1 || '%'
+		    OR to_tsvector('english', stock_name) @@ plainto_tsquery('english', $1)
+		 ORDER BY stock_name
+		 LIMIT 20`, q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error searching stocks"})
+		return
+	}
+	defer rows.Close()
+
+	type stockResult struct {
+		StockID   int    `json:"stock_id"`
+		StockName string `json:"stock_name"`
+	}
+	results := []stockResult{}
+	for rows.Next() {
+		var s stockResult
+		if err := rows.Scan(&s.StockID, &s.StockName); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error scanning stocks"})
+			return
+		}
+		results = append(results, s)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error iterating stocks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{Success: true, Data: results})
+}
+
+// syncStockQuantityHandler stores a stock's total quantity as reported by
+// order-service, which owns the authoritative count in Cassandra. Called
+// after every addStockToUser so both stores agree.
+func syncStockQuantityHandler(c *gin.Context) {
+	var req struct {
+		StockID  int `json:"stock_id"`
+		Quantity int `json:"quantity"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.StockID <= 0 || req.Quantity < 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "stock_id and a non-negative quantity are required"})
+		return
+	}
+
+	// The stocks row may not exist yet if the rename mirror never ran for
+	// this stock; insert with an empty name that a later rename fills in.
+	_, err := portfolioDB.ExecContext(c,
+		`INSERT INTO stocks (stock_id, stock_name, quantity)
+		 VALUES ($1, '', $2)
+		 ON CONFLICT (stock_id) DO UPDATE
+		 SET quantity = EXCLUDED.quantity, updated_at = current_timestamp`,
+		req.StockID, req.Quantity)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to sync stock quantity"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// StockQuantityMismatch is one discrepancy found by the quantity audit.
+type StockQuantityMismatch struct {
+	StockID           int `json:"stock_id"`
+	CassandraQuantity int `json:"cassandra_quantity"`
+	CockroachQuantity int `json:"cockroach_quantity"`
+}
+
+// auditStockQuantitiesHandler compares the quantities mirrored here against
+// order-service's authoritative Cassandra counts and reports any drift.
+func auditStockQuantitiesHandler(c *gin.Context) {
+	url := serviceURL("ORDER_SERVICE_URL", "http://order-service:8081") + "/internal/getAllStocks"
+	resp, err := priceGet(c.Request.Context(), url)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Could not reach order service: " + err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: fmt.Sprintf("Order service returned status %d", resp.StatusCode)})
+		return
+	}
+	var body struct {
+		Data []struct {
+			StockID  int `json:"stock_id"`
+			Quantity int `json:"quantity"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		c.JSON(http.StatusBadGateway, Response{Success: false, Message: "Malformed order service response"})
+		return
+	}
+
+	rows, err := dbPool.reads(c).QueryContext(c, `SELECT stock_id, quantity FROM stocks`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error querying stocks"})
+		return
+	}
+	defer rows.Close()
+
+	local := make(map[int]int)
+	for rows.Next() {
+		var stockID, quantity int
+		if err := rows.Scan(&stockID, &quantity); err != nil {
+			c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error scanning stocks"})
+			return
+		}
+		local[stockID] = quantity
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error iterating stocks"})
+		return
+	}
+
+	mismatches := []StockQuantityMismatch{}
+	for _, s := range body.Data {
+		if local[s.StockID] != s.Quantity {
+			mismatches = append(mismatches, StockQuantityMismatch{
+				StockID:           s.StockID,
+				CassandraQuantity: s.Quantity,
+				CockroachQuantity: local[s.StockID],
+			})
+		}
+	}
+	for _, m := range mismatches {
+		log.Printf("⚠️ stock %d quantity drift: cassandra=%d cockroach=%d",
+			m.StockID, m.CassandraQuantity, m.CockroachQuantity)
+	}
+
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{
+		"stocks_checked": len(body.Data),
+		"mismatches":     mismatches,
+	}})
+}
+
+// adjustWalletBalance applies a signed amount to a user's wallet inside a
+// transaction and records a wallet_transactions row tagged with txType
+// (e.g. TRADE, FEE). Returns the new wallet transaction ID. A debit that
+// would take the balance negative fails with errInsufficientFunds.
+var errInsufficientFunds = fmt.Errorf("insufficient funds")
+
+// errInsufficientStock mirrors errInsufficientFunds for portfolio holdings.
+var errInsufficientStock = fmt.Errorf("insufficient stock quantity")
+
+func adjustWalletBalance(c *gin.Context, userID int, amount float64, isDebit bool, txType string) (string, error) {
+	walletID, err := createWalletIfNotExists(c, userID)
+	if err != nil {
+		return "", err
+	}
+
+	var walletTxID string
+	err = withRetry(c, 3, func() error {
+		tx, err := portfolioDB.BeginTx(c, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if isDebit {
+			// Single conditional UPDATE so the balance check and the debit
+			// cannot be reordered under concurrent transactions; no matching
+			// row means the balance was insufficient.
+			var newBalance float64
+			err = tx.QueryRowContext(c,
+				`UPDATE wallet
+				 SET balance = balance - $1, updated_at = current_timestamp
+				 WHERE wallet_id = $2 AND balance >= $1
+				 RETURNING balance`, amount, walletID).Scan(&newBalance)
+			if err == sql.ErrNoRows {
+				return errInsufficientFunds
+			}
+		} else {
+			_, err = tx.ExecContext(c,
+				`UPDATE wallet
+				 SET balance = balance + $1, updated_at = current_timestamp
+				 WHERE wallet_id = $2`, amount, walletID)
+		}
+		if err != nil {
+			return err
+		}
+
+		walletTxID = uuid.NewString()
+		_, err = tx.ExecContext(c,
+			`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, tx_type, amount)
+			 VALUES ($1, $2, $3, $4, $5)`, walletTxID, walletID, isDebit, txType, amount)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	})
+	if err != nil {
+		return "", err
+	}
+	return walletTxID, nil
+}
+
+// deductMoneyInternalHandler debits a user's wallet. Used by the matching
+// service when settling the buy side of a trade.
+func deductMoneyInternalHandler(c *gin.Context) {
+	var req struct {
+		UserID int     `json:"user_id"`
+		Amount float64 `json:"amount"`
+		TxType string  `json:"tx_type"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Amount must be > 0"})
+		return
+	}
+	if req.TxType == "" {
+		req.TxType = "TRADE"
+	}
+	walletTxID, err := adjustWalletBalance(c, req.UserID, req.Amount, true, req.TxType)
+	if err == errInsufficientFunds {
+		c.JSON(http.StatusPaymentRequired, Response{Success: false, Message: "Insufficient funds"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to deduct money"})
+		return
+	}
+	dropUserCache(c.Request.Context(), fmt.Sprintf("wallet_balance:%d", req.UserID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"wallet_tx_id": walletTxID}})
+}
+
+// addMoneyInternalHandler credits a user's wallet. Used by the matching
+// service when settling the sell side of a trade.
+func addMoneyInternalHandler(c *gin.Context) {
+	var req struct {
+		UserID int     `json:"user_id"`
+		Amount float64 `json:"amount"`
+		TxType string  `json:"tx_type"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Amount must be > 0"})
+		return
+	}
+	if req.TxType == "" {
+		req.TxType = "TRADE"
+	}
+	walletTxID, err := adjustWalletBalance(c, req.UserID, req.Amount, false, req.TxType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to add money"})
+		return
+	}
+	dropUserCache(c.Request.Context(), fmt.Sprintf("wallet_balance:%d", req.UserID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"wallet_tx_id": walletTxID}})
+}
+
+// zeroOutUserHandler empties a user's wallet and portfolio ahead of account
+// deletion. The wallet row itself is kept so historical wallet_transactions
+// stay referentially intact; the final balance drain is recorded as an
+// ACCOUNT_CLOSURE debit.
+func zeroOutUserHandler(c *gin.Context) {
+	var req struct {
+		UserID int `json:"user_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid request body"})
+		return
+	}
+
+	var walletID string
+	err := portfolioDB.QueryRowContext(c,
+		`SELECT wallet_id FROM wallet WHERE user_id=$1`, req.UserID).Scan(&walletID)
+	if err == sql.ErrNoRows {
+		// Nothing to clean up
+		c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error looking up wallet"})
+		return
+	}
+
+	err = withRetry(c, 3, func() error {
+		tx, err := portfolioDB.BeginTx(c, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var balance float64
+		if err := tx.QueryRowContext(c,
+			`SELECT balance FROM wallet WHERE wallet_id=$1`, walletID).Scan(&balance); err != nil {
+			return err
+		}
+		if balance > 0 {
+			_, err = tx.ExecContext(c,
+				`INSERT INTO wallet_transactions (wallet_tx_id, wallet_id, is_debit, tx_type, amount)
+				 VALUES ($1, $2, true, 'ACCOUNT_CLOSURE', $3)`,
+				uuid.NewString(), walletID, balance)
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(c,
+			`UPDATE wallet SET balance = 0, updated_at = current_timestamp WHERE wallet_id = $1`,
+			walletID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(c,
+			`DELETE FROM stock_portfolio WHERE wallet_id = $1`, walletID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to zero out user"})
+		return
+	}
+	dropUserCache(c.Request.Context(),
+		fmt.Sprintf("wallet_balance:%d", req.UserID),
+		fmt.Sprintf("portfolio:%d", req.UserID))
+	c.JSON(http.StatusOK, Response{Success: true, Data: nil})
+}
+
+// getWalletBalanceInternalHandler reads a user's balance by user_id, for
+// services that do not go through the gateway.
+func getWalletBalanceInternalHandler(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Query("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{Success: false, Message: "Invalid user_id"})
+		return
+	}
+	walletID, err := createWalletIfNotExists(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Failed to create/fetch wallet"})
+		return
+	}
+	var balance float64
+	err = dbPool.reads(c).QueryRowContext(c,
+		`SELECT balance FROM wallet WHERE wallet_id=$1`, walletID).Scan(&balance)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{Success: false, Message: "Error reading balance"})
+		return
+	}
+	c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"balance": balance}})
+}
+
+// internalAuthRequired validates the shared X-Internal-Secret header on the
+// money- and portfolio-mutating internal endpoints, so another process on the
+// Docker network cannot impersonate matching-service. When INTERNAL_API_SECRET
+// is unset the check is skipped, matching the other services' middleware.
+func internalAuthRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := os.Getenv("INTERNAL_API_SECRET")
+		if secret != "" && c.GetHeader("X-Internal-Secret") != secret {
+			c.JSON(http.StatusUnauthorized, Response{Success: false, Message: "Invalid internal secret"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// panicRecovery recovers a panicking handler into a 500 response. Beyond what
+// gin.Recovery does, the panic value and the top of the stack go out as a
+// PANIC alert on the shared Redis "service-alerts" channel.
+func panicRecovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		stack := string(debug.Stack())
+		log.Printf("PANIC in %s %s: %v\n%s", c.Request.Method, c.Request.URL.Path, recovered, stack)
+
+		lines := strings.Split(stack, "\n")
+		if len(lines) > 20 {
+			lines = lines[:20]
+		}
+		alert, _ := json.Marshal(gin.H{
+			"type":         "PANIC",
+			"service_name": "wallet-portfolio",
+			"panic_value":  fmt.Sprintf("%v", recovered),
+			"stack":        strings.Join(lines, "\n"),
+			"timestamp":    time.Now().Format(time.RFC3339),
+		})
+		if err := redisClient.Publish(context.Background(), "service-alerts", alert).Err(); err != nil {
+			log.Println("Warning: could not publish panic alert:", err)
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// setupRouter registers every route on a fresh gin engine. Split out of main
+// so tests can drive the handlers without starting a listener.
+func setupRouter() *gin.Engine {
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	r.Use(gin.Logger(), panicRecovery())
 
 	r.POST("/addMoneyToWallet", addMoneyHandler)
+	r.POST("/transferBetweenAccounts", transferBetweenAccountsHandler)
 	r.GET("/getWalletBalance", getWalletBalanceHandler)
 	r.GET("/getWalletTransactions", getWalletTransactionsHandler)
 	r.GET("/getStockPortfolio", getStockPortfolioHandler)
+	r.GET("/getNetWorth", getNetWorthHandler)
+
+	// Gateway-facing setup route (forwarded without a prefix strip)
+	r.GET("/setup/searchStocks", searchStocksHandler)
+
+	// Internal endpoints for other services (not proxied by the gateway)
+	r.POST("/internal/updateStockPortfolio", internalAuthRequired(), updateStockPortfolioHandler)
+	// Company-stock mirrors only order-service may call: it verifies stock
+	// ownership before mutating, so direct calls must not bypass that check.
+	r.PATCH("/internal/updateStockName", internalAuthRequired(), updateStockNameHandler)
+	r.POST("/internal/syncStockQuantity", internalAuthRequired(), syncStockQuantityHandler)
+	r.GET("/internal/auditStockQuantities", auditStockQuantitiesHandler)
+	r.POST("/internal/deductMoneyFromWallet", internalAuthRequired(), deductMoneyInternalHandler)
+	r.POST("/internal/addMoneyToWallet", internalAuthRequired(), addMoneyInternalHandler)
+	r.GET("/internal/getWalletBalance", getWalletBalanceInternalHandler)
+	r.POST("/internal/zeroOutUser", zeroOutUserHandler)
+
+	if sandboxMode() {
+		r.DELETE("/internal/sandbox/reset", sandboxResetHandler)
+	}
+
+	// Connection pool statistics for Prometheus scrapes
+	r.GET("/metrics", metricsHandler)
+
+	// Kubernetes-style probes: liveness answers immediately, readiness
+	// requires CockroachDB to be reachable.
+	r.GET("/health/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"status": "alive"}})
+	})
+	r.GET("/health/ready", func(c *gin.Context) {
+		if err := portfolioDB.PingContext(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, Response{Success: false, Message: "Database unreachable: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, Response{Success: true, Data: gin.H{"status": "ready"}})
+	})
+
+	return r
+}
+
+func main() {
+	bootstrap()
+	if sandboxMode() {
+		gin.SetMode(gin.TestMode)
+	} else {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	r := setupRouter()
+	go logDBStatsLoop()
 
 	port := os.Getenv("PORT")
 	if port == "" {
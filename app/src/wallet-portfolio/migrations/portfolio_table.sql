@@ -18,12 +18,33 @@ CREATE TABLE IF NOT EXISTS stock_portfolio (
     FOREIGN KEY (wallet_id) REFERENCES wallet(wallet_id) ON DELETE CASCADE
 );
 
+CREATE TABLE IF NOT EXISTS stocks (
+    stock_id INTEGER PRIMARY KEY,
+    stock_name VARCHAR(100) NOT NULL,
+    quantity INTEGER NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- stocks predates the quantity mirror; bring existing databases up to date
+ALTER TABLE stocks ADD COLUMN IF NOT EXISTS quantity INTEGER NOT NULL DEFAULT 0;
+
+-- Full-text search over stock names
+CREATE INVERTED INDEX IF NOT EXISTS idx_stocks_name_fts ON stocks (to_tsvector('english', stock_name));
+
 CREATE TABLE IF NOT EXISTS wallet_transactions (
     wallet_tx_id VARCHAR(36) PRIMARY KEY,
     wallet_id VARCHAR(36) NOT NULL,
     stock_tx_id VARCHAR(100),
     is_debit BOOLEAN NOT NULL,
+    tx_type VARCHAR(20) NOT NULL DEFAULT 'TRADE',
     amount DECIMAL(20, 2) NOT NULL,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     FOREIGN KEY (wallet_id) REFERENCES wallet(wallet_id) ON DELETE CASCADE
-);
\ No newline at end of file
+);
+-- wallet_transactions predates tx_type; bring existing databases up to date
+ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS tx_type VARCHAR(20) NOT NULL DEFAULT 'TRADE';
+
+-- Peer-to-peer transfers: the debit and credit rows of one transfer share a
+-- transfer_id, and the sender may attach a short memo.
+ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS transfer_id VARCHAR(36);
+ALTER TABLE wallet_transactions ADD COLUMN IF NOT EXISTS memo VARCHAR(255);
@@ -0,0 +1,16 @@
+module gen-schema-docs
+
+go 1.22
+
+toolchain go1.24.0
+
+require (
+	github.com/gocql/gocql v1.5.2
+	github.com/lib/pq v1.10.9
+)
+
+require (
+	github.com/golang/snappy v0.0.3 // indirect
+	github.com/hailocab/go-hostpool v0.0.0-20160125115350-e80d13ce29ed // indirect
+	gopkg.in/inf.v0 v0.9.1 // indirect
+)
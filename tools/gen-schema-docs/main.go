@@ -0,0 +1,275 @@
+// gen-schema-docs generates SCHEMA.md from the live databases.
+//
+// It connects to the same stores the services use - Cassandra
+// (order-service), CockroachDB (wallet-portfolio) and TimescaleDB
+// (order-history) - reading connection details from the services' own
+// environment variables, and documents every table: columns, types, indexes
+// and foreign keys. Stores that cannot be reached are skipped with a note so
+// the tool is still useful against a partial local stack.
+//
+// Run via `make schema-docs` from the repository root; CI compares the
+// regenerated file against the committed one.
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gocql/gocql"
+	_ "github.com/lib/pq"
+)
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// ----------------------------------------------------------------------------
+// Cassandra (order-service keyspaces)
+// ----------------------------------------------------------------------------
+
+// describeCassandra documents every table in the given keyspaces from
+// system_schema, which is what DESCRIBE TABLE reads server-side.
+func describeCassandra(out *strings.Builder, keyspaces []string) error {
+	cluster := gocql.NewCluster(envOr("CASSANDRA_DB_HOST", "localhost"))
+	if port := os.Getenv("CASSANDRA_DB_PORT"); port != "" {
+		fmt.Sscanf(port, "%d", &cluster.Port)
+	}
+	cluster.Authenticator = gocql.PasswordAuthenticator{
+		Username: os.Getenv("DB_USER"),
+		Password: os.Getenv("DB_PASSWORD"),
+	}
+	cluster.Consistency = gocql.One
+
+	session, err := cluster.CreateSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	for _, keyspace := range keyspaces {
+		fmt.Fprintf(out, "## Cassandra keyspace `%s`\n\n", keyspace)
+
+		var tables []string
+		iter := session.Query(`
+            SELECT table_name FROM system_schema.tables WHERE keyspace_name = ?
+        `, keyspace).Iter()
+		var table string
+		for iter.Scan(&table) {
+			tables = append(tables, table)
+		}
+		if err := iter.Close(); err != nil {
+			return err
+		}
+		sort.Strings(tables)
+
+		for _, table := range tables {
+			fmt.Fprintf(out, "### `%s.%s`\n\n", keyspace, table)
+			out.WriteString("| Column | Type | Kind |\n|---|---|---|\n")
+
+			iter := session.Query(`
+                SELECT column_name, type, kind
+                FROM system_schema.columns
+                WHERE keyspace_name = ? AND table_name = ?
+            `, keyspace, table).Iter()
+			var name, cqlType, kind string
+			type column struct{ name, cqlType, kind string }
+			var columns []column
+			for iter.Scan(&name, &cqlType, &kind) {
+				columns = append(columns, column{name, cqlType, kind})
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+			sort.Slice(columns, func(i, j int) bool { return columns[i].name < columns[j].name })
+			for _, col := range columns {
+				fmt.Fprintf(out, "| %s | %s | %s |\n", col.name, col.cqlType, col.kind)
+			}
+			out.WriteString("\n")
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// Postgres-family stores (CockroachDB, TimescaleDB)
+// ----------------------------------------------------------------------------
+
+// describePostgres documents every public table in one Postgres-compatible
+// database: columns from information_schema.columns, indexes from pg_indexes
+// and foreign keys from information_schema constraint tables.
+func describePostgres(out *strings.Builder, title, dsn string) error {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "## %s\n\n", title)
+
+	rows, err := db.Query(`
+        SELECT table_name FROM information_schema.tables
+        WHERE table_schema = 'public' AND table_type = 'BASE TABLE'
+        ORDER BY table_name
+    `)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, t)
+	}
+	rows.Close()
+
+	for _, table := range tables {
+		fmt.Fprintf(out, "### `%s`\n\n", table)
+
+		out.WriteString("| Column | Type | Nullable | Default |\n|---|---|---|---|\n")
+		cols, err := db.Query(`
+            SELECT column_name, data_type, is_nullable, COALESCE(column_default, '')
+            FROM information_schema.columns
+            WHERE table_schema = 'public' AND table_name = $1
+            ORDER BY ordinal_position
+        `, table)
+		if err != nil {
+			return err
+		}
+		for cols.Next() {
+			var name, dataType, nullable, def string
+			if err := cols.Scan(&name, &dataType, &nullable, &def); err != nil {
+				cols.Close()
+				return err
+			}
+			fmt.Fprintf(out, "| %s | %s | %s | %s |\n", name, dataType, nullable, def)
+		}
+		cols.Close()
+		out.WriteString("\n")
+
+		idx, err := db.Query(`
+            SELECT indexname, indexdef FROM pg_indexes
+            WHERE schemaname = 'public' AND tablename = $1
+            ORDER BY indexname
+        `, table)
+		if err != nil {
+			return err
+		}
+		wroteHeader := false
+		for idx.Next() {
+			var name, def string
+			if err := idx.Scan(&name, &def); err != nil {
+				idx.Close()
+				return err
+			}
+			if !wroteHeader {
+				out.WriteString("Indexes:\n\n")
+				wroteHeader = true
+			}
+			fmt.Fprintf(out, "- `%s`: %s\n", name, def)
+		}
+		idx.Close()
+		if wroteHeader {
+			out.WriteString("\n")
+		}
+
+		fks, err := db.Query(`
+            SELECT tc.constraint_name, kcu.column_name,
+                   ccu.table_name AS foreign_table, ccu.column_name AS foreign_column
+            FROM information_schema.table_constraints tc
+            JOIN information_schema.key_column_usage kcu
+              ON tc.constraint_name = kcu.constraint_name
+            JOIN information_schema.constraint_column_usage ccu
+              ON tc.constraint_name = ccu.constraint_name
+            WHERE tc.constraint_type = 'FOREIGN KEY'
+              AND tc.table_schema = 'public' AND tc.table_name = $1
+            ORDER BY tc.constraint_name
+        `, table)
+		if err != nil {
+			return err
+		}
+		wroteHeader = false
+		for fks.Next() {
+			var constraint, column, foreignTable, foreignColumn string
+			if err := fks.Scan(&constraint, &column, &foreignTable, &foreignColumn); err != nil {
+				fks.Close()
+				return err
+			}
+			if !wroteHeader {
+				out.WriteString("Foreign keys:\n\n")
+				wroteHeader = true
+			}
+			fmt.Fprintf(out, "- `%s`: %s -> %s(%s)\n", constraint, column, foreignTable, foreignColumn)
+		}
+		fks.Close()
+		if wroteHeader {
+			out.WriteString("\n")
+		}
+	}
+	return nil
+}
+
+func cockroachDSN() string {
+	return fmt.Sprintf("postgresql://root@%s/portfolio-db?sslmode=disable",
+		envOr("COCKROACH_DB_HOST", "localhost:26257"))
+}
+
+func timescaleDSN() string {
+	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable",
+		envOr("TIMESCALE_USER", "history_user"),
+		envOr("TIMESCALE_PASSWORD", "history_password"),
+		envOr("TIMESCALE_HOST", "localhost"),
+		envOr("TIMESCALE_PORT", "5432"),
+		envOr("TIMESCALE_DB", "order_history"))
+}
+
+func authDSN() string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		envOr("DB_HOST", "localhost"), envOr("DB_PORT", "5432"),
+		envOr("DB_USER", "SENG468"), envOr("DB_PASSWORD", "SENG468-Student"),
+		envOr("DB_NAME", "user-login"), envOr("DB_SSLMODE", "disable"))
+}
+
+func main() {
+	outPath := flag.String("out", "SCHEMA.md", "path of the generated markdown file")
+	flag.Parse()
+
+	var out strings.Builder
+	out.WriteString("# Database schemas\n\n")
+	out.WriteString("Generated by `make schema-docs` (tools/gen-schema-docs). Do not edit by hand.\n\n")
+
+	if err := describeCassandra(&out, []string{"orders_keyspace", "stocks_keyspace"}); err != nil {
+		log.Println("Warning: skipping Cassandra:", err)
+		out.WriteString("## Cassandra\n\n_Unreachable while generating._\n\n")
+	}
+	if err := describePostgres(&out, "CockroachDB `portfolio-db` (wallet-portfolio)", cockroachDSN()); err != nil {
+		log.Println("Warning: skipping CockroachDB:", err)
+		out.WriteString("## CockroachDB\n\n_Unreachable while generating._\n\n")
+	}
+	if err := describePostgres(&out, "TimescaleDB `order_history` (order-history)", timescaleDSN()); err != nil {
+		log.Println("Warning: skipping TimescaleDB:", err)
+		out.WriteString("## TimescaleDB\n\n_Unreachable while generating._\n\n")
+	}
+	if err := describePostgres(&out, "Postgres `user-login` (authentication)", authDSN()); err != nil {
+		log.Println("Warning: skipping auth Postgres:", err)
+		out.WriteString("## Postgres (authentication)\n\n_Unreachable while generating._\n\n")
+	}
+
+	if err := os.WriteFile(*outPath, []byte(out.String()), 0o644); err != nil {
+		log.Fatal("writing schema docs:", err)
+	}
+	fmt.Println("✅ wrote", *outPath)
+}